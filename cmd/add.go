@@ -1,106 +1,277 @@
 package cmd
 
 import (
-	"cmp"
 	"fmt"
 	"os"
 	"path/filepath"
 	"slices"
-	"syscall"
-	"time"
+	"strings"
 
 	"github.com/kbraun9118/wyog/repository"
-	"github.com/kbraun9118/wyog/util"
 	"github.com/spf13/cobra"
 )
 
-var addCmd = &cobra.Command{
-	Use:   "add paths...",
-	Short: "Add files contents to index.",
-	Args:  cobra.MinimumNArgs(1),
-	RunE: func(cmd *cobra.Command, paths []string) error {
-		p, err := repository.FindRequire(".")
+func init() {
+	addCmd.Flags().BoolVarP(&addAll, "all", "A", false, "Stage additions, modifications and deletions across the whole tree")
+	addCmd.Flags().BoolVarP(&addUpdate, "update", "u", false, "Only stage modifications and deletions of tracked files")
+	addCmd.Flags().BoolVarP(&addForce, "force", "f", false, "Allow adding otherwise ignored files")
+	addCmd.Flags().BoolVarP(&addDryRun, "dry-run", "n", false, "Don't actually add the files, just show what would happen")
+	addCmd.Flags().BoolVarP(&addVerbose, "verbose", "v", false, "Be verbose")
+	addCmd.Flags().BoolVarP(&addPatch, "patch", "p", false, "Interactively choose hunks of patch between the index and the work tree")
+	addCmd.MarkFlagsMutuallyExclusive("all", "update")
+}
+
+var (
+	addAll     bool
+	addUpdate  bool
+	addForce   bool
+	addDryRun  bool
+	addVerbose bool
+	addPatch   bool
+	addCmd     = &cobra.Command{
+		Use:   "add [-A | -u | -p] [-f] [-n] [pathspec...]",
+		Short: "Add files contents to index.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(p)
+			if err != nil {
+				return err
+			}
+
+			if addPatch {
+				spec, err := repo.ParsePathspec(args)
+				if err != nil {
+					return err
+				}
+				return addInteractive(&repo, spec)
+			}
+
+			if len(args) == 0 && !addAll && !addUpdate {
+				return fmt.Errorf("Nothing specified, nothing added.")
+			}
+
+			spec, err := repo.ParsePathspec(args)
+			if err != nil {
+				return err
+			}
+
+			return add(&repo, spec)
+		},
+	}
+)
+
+func add(repo *repository.Repository, spec *repository.Pathspec) error {
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	untracked, ignored, err := repo.UntrackedFiles(index)
+	if err != nil {
+		return err
+	}
+
+	tracked := make(map[string]repository.IndexEntry)
+	unmerged := make(map[string]bool)
+	for _, e := range index.Entries {
+		if e.Stage == 0 {
+			tracked[e.Name] = e
+		} else {
+			unmerged[e.Name] = true
+		}
+	}
+
+	known := slices.Concat(untracked, ignored)
+	for _, e := range index.Entries {
+		known = append(known, e.Name)
+	}
+	if unmatched := spec.Unmatched(known); len(unmatched) != 0 {
+		return fmt.Errorf("pathspec '%s' did not match any files", unmatched[0])
+	}
+
+	staged := make(map[string]repository.IndexEntry)
+	removed := make(map[string]bool)
+	stage := func(path string) error {
+		sha, err := repo.HashFile(path, !addDryRun)
 		if err != nil {
 			return err
 		}
-		repo, err := repository.New(p)
+		entry, err := repo.IndexEntryFromFile(path, sha)
 		if err != nil {
 			return err
 		}
+		old, ok := tracked[path]
+		if ok {
+			entry.SetMode(repo.StageMode(entry.Mode(), old.Mode()))
+		}
+		if !ok || old.Sha != sha || old.Mode() != entry.Mode() || unmerged[path] {
+			if addDryRun || addVerbose {
+				fmt.Printf("add '%s'\n", path)
+			}
+		}
+		staged[path] = entry
+		return nil
+	}
 
-		if err := repo.Rm(false, true, paths...); err != nil {
-			return err
+	paths := make([]string, 0, len(tracked)+len(unmerged))
+	for path := range tracked {
+		paths = append(paths, path)
+	}
+	for path := range unmerged {
+		if _, ok := tracked[path]; !ok {
+			paths = append(paths, path)
 		}
+	}
+	slices.Sort(paths)
 
-		cleanPaths := util.NewLinkedMap[string, string]()
-		for _, path := range paths {
-			absPath, err := filepath.Abs(path)
-			if err != nil {
-				return fmt.Errorf("cannot convert %s to absolute path", path)
-			}
+	for _, path := range paths {
+		if !spec.Match(path) {
+			continue
+		}
 
-			relPath, err := filepath.Rel(repo.Worktree, absPath)
-			if err != nil {
-				return fmt.Errorf("outside the worktree: %s", path)
+		if _, err := os.Lstat(filepath.Join(repo.Worktree, path)); err != nil {
+			if addDryRun || addVerbose {
+				fmt.Printf("remove '%s'\n", path)
 			}
-
-			cleanPaths.Set(absPath, relPath)
+			removed[path] = true
+			continue
 		}
 
-		index, err := repo.ReadIndex()
-		if err != nil {
+		if entry, ok := tracked[path]; ok && !unmerged[path] && repo.EntryUptodate(index, entry) {
+			continue
+		}
+		if err := stage(path); err != nil {
 			return err
 		}
+	}
 
-		for absPath, relPath := range cleanPaths.Iterate() {
-			file, err := os.Open(absPath)
-			if err != nil {
-				return fmt.Errorf("cannot open file: %s", absPath)
+	refused := make([]string, 0)
+	if !addUpdate {
+		for _, path := range untracked {
+			if spec.Match(path) {
+				if err := stage(path); err != nil {
+					return err
+				}
 			}
-			sha, err := ObjectHash(file, "blob", &repo)
-			if err != nil {
-				return err
-			}
-
-			stat, err := os.Stat(absPath)
-			if err != nil {
-				return fmt.Errorf("cannot stat file: %s", absPath)
-			}
-			if stat.IsDir() {
-				return fmt.Errorf("not a file: %s", relPath)
+		}
+		for _, path := range ignored {
+			if !spec.Match(path) {
+				continue
 			}
-			sysStat, ok := stat.Sys().(*syscall.Stat_t)
-			if !ok {
-				return fmt.Errorf("not syscall stat")
+			if addForce {
+				if err := stage(path); err != nil {
+					return err
+				}
+			} else if spec.Exact(path) {
+				refused = append(refused, path)
 			}
+		}
+	}
 
-			entry := repository.IndexEntry{
-				Ctime:       time.Unix(sysStat.Ctim.Sec, sysStat.Ctim.Nsec),
-				Mtime:       stat.ModTime(),
-				Dev:         int(sysStat.Dev),
-				Ino:         int(sysStat.Ino),
-				ModeType:    0b1000,
-				ModePerms:   0o644,
-				Uid:         int(sysStat.Uid),
-				Gid:         int(sysStat.Gid),
-				Fsize:       int(stat.Size()),
-				Sha:         sha,
-				AssumeValid: false,
-				Stage:       0,
-				Name:        relPath,
+	if !addDryRun {
+		entries := make([]repository.IndexEntry, 0, len(index.Entries)+len(staged))
+		for _, e := range index.Entries {
+			if _, ok := staged[e.Name]; ok || removed[e.Name] {
+				index.Invalidate(e.Name)
+				continue
 			}
+			entries = append(entries, e)
+		}
+		for _, e := range staged {
+			index.Invalidate(e.Name)
+			entries = append(entries, e)
+		}
+		index.Entries = entries
+		index.Sort()
 
-			index.Entries = append(index.Entries, entry)
+		if err := repo.WriteIndex(lock, index); err != nil {
+			return err
 		}
+	}
+
+	if len(refused) != 0 {
+		return fmt.Errorf(
+			"The following paths are ignored by one of your .gitignore files:\n%s\nUse -f if you really want to add them.",
+			strings.Join(refused, "\n"),
+		)
+	}
+
+	return nil
+}
 
-		slices.SortFunc(index.Entries, func(a, b repository.IndexEntry) int {
-			return cmp.Compare(a.Name, b.Name)
-		})
+// addInteractive stages hunks of the worktree changes to tracked files, leaving the
+// worktree itself untouched.
+func addInteractive(repo *repository.Repository, spec *repository.Pathspec) error {
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	changes, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return err
+	}
+
+	selector := newPatchSelector(repo, patchStage)
+	unmerged := index.Unmerged()
+	removed := make(map[string]bool)
+	shown := false
+
+	for i, e := range index.Entries {
+		if e.Stage != 0 || !slices.Contains(changes, e.Name) || !spec.Match(e.Name) {
+			continue
+		}
+		if slices.Contains(unmerged, e.Name) {
+			fmt.Printf("ignoring unmerged: %s\n", e.Name)
+			continue
+		}
+		shown = true
 
-		if err := repo.WriteIndex(index); err != nil {
+		data, err := repo.ReadWorktreeFile(e.Name)
+		if err != nil {
+			ok, err := selector.selectFile(e.Name, true)
+			if err != nil {
+				return err
+			}
+			removed[e.Name] = ok
+			continue
+		}
+
+		oldLines, err := blobLines(repo, e.Sha)
+		if err != nil {
 			return err
 		}
+		staged, changed, err := selector.selectHunks(e.Name, oldLines, repository.SplitLines(data))
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+
+		sha, err := repository.Write(repository.NewBlob(staged), repo)
+		if err != nil {
+			return err
+		}
+		entry := repository.IndexEntry{Sha: sha, Name: e.Name}
+		entry.SetMode(e.Mode())
+		index.Entries[i] = entry
+		index.Invalidate(e.Name)
+	}
 
+	if !shown {
+		fmt.Println("No changes.")
 		return nil
-	},
+	}
+
+	index.Entries = slices.DeleteFunc(index.Entries, func(e repository.IndexEntry) bool {
+		if removed[e.Name] {
+			index.Invalidate(e.Name)
+		}
+		return removed[e.Name]
+	})
+	return repo.WriteIndex(lock, index)
 }
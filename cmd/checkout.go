@@ -36,11 +36,11 @@ var checkoutCmd = &cobra.Command{
 		}
 
 		if commitObj, ok := obj.(*repository.Commit); ok {
-			tree, ok := commitObj.Kvlm.Get("tree")
-			if !ok {
+			tree := commitObj.Tree()
+			if tree == "" {
 				return fmt.Errorf("cannot find tree object")
 			}
-			obj, err = repository.ReadObj(&repo, tree[0])
+			obj, err = repository.ReadObj(&repo, tree)
 		}
 
 		treeObj, ok := obj.(*repository.Tree)
@@ -52,7 +52,7 @@ var checkoutCmd = &cobra.Command{
 			if !pathStat.IsDir() {
 				return fmt.Errorf("Not a directory %s", path)
 			}
-			if pathDir, err := os.ReadDir(path); err == nil || len(pathDir) != 0 {
+			if pathDir, err := os.ReadDir(path); err == nil && len(pathDir) != 0 {
 				return fmt.Errorf("Not empty %s", path)
 			}
 		} else {
@@ -90,8 +90,18 @@ func treeCheckout(repo *repository.Repository, tree *repository.Tree, path strin
 				return err
 			}
 		case *repository.Blob:
-			err := os.WriteFile(dest, objType.Serialize(), 0644)
-			if err != nil {
+			if item.Mode == repository.ModeSymlink {
+				if err := os.Symlink(string(objType.Serialize()), dest); err != nil {
+					return fmt.Errorf("cannot create symlink %s", dest)
+				}
+				continue
+			}
+
+			perm := os.FileMode(0644)
+			if item.Mode.Perms()&0o111 != 0 {
+				perm = 0755
+			}
+			if err := os.WriteFile(dest, objType.Serialize(), perm); err != nil {
 				return fmt.Errorf("cannot write file %s", dest)
 			}
 		default:
@@ -0,0 +1,368 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	ignore "github.com/sabhiram/go-gitignore"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	cleanCmd.Flags().BoolVarP(&cleanDryRun, "dry-run", "n", false, "Don't actually remove anything, just show what would be done")
+	cleanCmd.Flags().BoolVarP(&cleanForce, "force", "f", false, "Required unless clean.requireForce is set to false")
+	cleanCmd.Flags().BoolVarP(&cleanDirs, "directories", "d", false, "Also remove untracked directories")
+	cleanCmd.Flags().BoolVarP(&cleanIgnored, "ignored", "x", false, "Also remove ignored files")
+	cleanCmd.Flags().BoolVarP(&cleanOnlyIgnored, "only-ignored", "X", false, "Remove only files ignored by wyog")
+	cleanCmd.Flags().StringArrayVarP(&cleanExcludes, "exclude", "e", nil, "Use the given exclude pattern in addition to the standard ignore rules")
+	cleanCmd.Flags().BoolVarP(&cleanInteractive, "interactive", "i", false, "Show what would be done and clean files interactively")
+	cleanCmd.MarkFlagsMutuallyExclusive("ignored", "only-ignored")
+}
+
+var (
+	cleanDryRun      bool
+	cleanForce       bool
+	cleanDirs        bool
+	cleanIgnored     bool
+	cleanOnlyIgnored bool
+	cleanExcludes    []string
+	cleanInteractive bool
+	cleanCmd         = &cobra.Command{
+		Use:   "clean [-n] [-f] [-d] [-x | -X] [-e pattern] [-i] [paths...]",
+		Short: "Remove untracked files from the working tree.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			requireForce, err := repo.Conf.Section("clean").Key("requireForce").Bool()
+			if err != nil {
+				requireForce = true
+			}
+			if requireForce && !cleanForce && !cleanDryRun && !cleanInteractive {
+				return fmt.Errorf("clean.requireForce defaults to true and neither -i, -n, nor -f given; refusing to clean")
+			}
+
+			spec, err := repo.ParsePathspec(args)
+			if err != nil {
+				return err
+			}
+
+			targets, err := cleanTargets(&repo, spec)
+			if err != nil {
+				return err
+			}
+
+			if cleanInteractive {
+				targets, err = cleanSelect(targets)
+				if err != nil {
+					return err
+				}
+			}
+
+			for _, target := range targets {
+				if cleanDryRun {
+					fmt.Printf("Would remove %s\n", target)
+					continue
+				}
+				fmt.Printf("Removing %s\n", target)
+				if err := os.RemoveAll(filepath.Join(repo.Worktree, target)); err != nil {
+					return fmt.Errorf("failed to remove %s", target)
+				}
+			}
+
+			return nil
+		},
+	}
+)
+
+// cleanTargets lists the files and directories (with a trailing slash) to remove.
+func cleanTargets(repo *repository.Repository, spec *repository.Pathspec) ([]string, error) {
+	index, err := repo.ReadIndex()
+	if err != nil {
+		return nil, err
+	}
+	untracked, ignored, err := repo.UntrackedFiles(index)
+	if err != nil {
+		return nil, err
+	}
+
+	// paths matched by -e are kept whatever the mode, but still keep their directory
+	excludes := ignore.CompileIgnoreLines(cleanExcludes...)
+	excluded := make([]string, 0)
+	for _, paths := range []*[]string{&untracked, &ignored} {
+		*paths = slices.DeleteFunc(*paths, func(path string) bool {
+			if len(cleanExcludes) != 0 && excludes.MatchesPath(path) {
+				excluded = append(excluded, path)
+				return true
+			}
+			return false
+		})
+	}
+
+	var candidates []string
+	switch {
+	case cleanOnlyIgnored:
+		candidates = ignored
+	case cleanIgnored:
+		candidates = slices.Concat(untracked, ignored)
+	default:
+		candidates = untracked
+	}
+	candidates = slices.DeleteFunc(candidates, func(path string) bool {
+		return !spec.Match(path)
+	})
+	slices.Sort(candidates)
+
+	trackedDirs := make(map[string]bool)
+	for _, e := range index.Entries {
+		for dir := filepath.Dir(e.Name); dir != "."; dir = filepath.Dir(dir) {
+			trackedDirs[dir] = true
+		}
+	}
+
+	// every file that lives in an untracked directory, grouped by the outermost such directory
+	dirFiles := make(map[string][]string)
+	for _, path := range slices.Concat(untracked, ignored, excluded) {
+		if dir := untrackedDir(path, trackedDirs); dir != "" {
+			dirFiles[dir] = append(dirFiles[dir], path)
+		}
+	}
+
+	ret := make([]string, 0)
+	for _, path := range candidates {
+		dir := untrackedDir(path, trackedDirs)
+		if dir == "" {
+			ret = append(ret, path)
+			continue
+		}
+		if !cleanDirs {
+			continue
+		}
+
+		whole := !slices.ContainsFunc(dirFiles[dir], func(p string) bool {
+			return !slices.Contains(candidates, p)
+		})
+		if whole && spec.Match(dir) {
+			if !slices.Contains(ret, dir+"/") {
+				ret = append(ret, dir+"/")
+			}
+		} else {
+			ret = append(ret, path)
+		}
+	}
+
+	if cleanDirs && !cleanOnlyIgnored {
+		files := slices.Concat(untracked, ignored, excluded)
+		for _, e := range index.Entries {
+			files = append(files, e.Name)
+		}
+		empty, err := emptyDirs(repo, files, trackedDirs)
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range empty {
+			inRemoved := slices.ContainsFunc(ret, func(t string) bool {
+				return strings.HasSuffix(t, "/") && strings.HasPrefix(dir+"/", t)
+			})
+			if inRemoved || !spec.Match(dir) || len(cleanExcludes) != 0 && excludes.MatchesPath(dir) {
+				continue
+			}
+			ret = append(ret, dir+"/")
+		}
+		slices.Sort(ret)
+	}
+
+	return ret, nil
+}
+
+// emptyDirs lists the outermost directories of the worktree that hold no files at all and
+// aren't ignored, unless -x is given. UntrackedFiles only lists files, so these would be
+// missed otherwise.
+func emptyDirs(repo *repository.Repository, files []string, trackedDirs map[string]bool) ([]string, error) {
+	rules, err := repo.ReadGitignore()
+	if err != nil {
+		return nil, err
+	}
+	full := make(map[string]bool)
+	for _, file := range files {
+		for dir := filepath.Dir(file); dir != "."; dir = filepath.Dir(dir) {
+			full[dir] = true
+		}
+	}
+
+	ret := make([]string, 0)
+	err = filepath.WalkDir(repo.Worktree, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || path == repo.Worktree {
+			return nil
+		}
+		if path == repo.Gitdir {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(repo.Worktree, path)
+		if err != nil {
+			return err
+		}
+		if full[rel] || trackedDirs[rel] {
+			return nil
+		}
+		if isIgnored, err := rules.CheckIgnore(rel); err != nil || isIgnored && !cleanIgnored {
+			return err
+		}
+		ret = append(ret, rel)
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot walk worktree: %v", err)
+	}
+	return ret, nil
+}
+
+func untrackedDir(path string, trackedDirs map[string]bool) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	for i := 1; i < len(parts); i++ {
+		dir := filepath.Join(parts[:i]...)
+		if !trackedDirs[dir] {
+			return dir
+		}
+	}
+	return ""
+}
+
+func cleanSelect(targets []string) ([]string, error) {
+	in := bufio.NewReader(os.Stdin)
+	prompt := func(text string) (string, error) {
+		fmt.Print(text)
+		line, err := in.ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return "", fmt.Errorf("no input")
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	for len(targets) != 0 {
+		fmt.Println("Would remove the following items:")
+		for _, t := range targets {
+			fmt.Printf("  %s\n", t)
+		}
+		fmt.Println("*** Commands ***")
+		fmt.Println("    1: clean                2: filter by pattern    3: select by numbers")
+		fmt.Println("    4: ask each             5: quit                 6: help")
+
+		choice, err := prompt("What now> ")
+		if err != nil {
+			return nil, err
+		}
+
+		switch choice {
+		case "1", "c", "clean":
+			return targets, nil
+		case "2", "f", "filter by pattern":
+			pattern, err := prompt("Input ignore patterns>> ")
+			if err != nil {
+				return nil, err
+			}
+			if len(pattern) == 0 {
+				continue
+			}
+			matcher := ignore.CompileIgnoreLines(strings.Fields(pattern)...)
+			targets = slices.DeleteFunc(targets, func(t string) bool {
+				return matcher.MatchesPath(strings.TrimSuffix(t, "/"))
+			})
+		case "3", "s", "select by numbers":
+			for i, t := range targets {
+				fmt.Printf("  %d: %s\n", i+1, t)
+			}
+			selection, err := prompt("Select items to delete>> ")
+			if err != nil {
+				return nil, err
+			}
+			selected, err := cleanParseSelection(selection, targets)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			targets = selected
+		case "4", "a", "ask each":
+			selected := make([]string, 0)
+			for _, t := range targets {
+				answer, err := prompt(fmt.Sprintf("Remove %s [y/N]? ", t))
+				if err != nil {
+					return nil, err
+				}
+				if strings.HasPrefix(strings.ToLower(answer), "y") {
+					selected = append(selected, t)
+				}
+			}
+			return selected, nil
+		case "5", "q", "quit":
+			fmt.Println("Bye.")
+			return nil, nil
+		case "6", "h", "help":
+			fmt.Println("clean               - start cleaning")
+			fmt.Println("filter by pattern   - exclude items from deletion")
+			fmt.Println("select by numbers   - select items to be deleted by numbers")
+			fmt.Println("ask each            - confirm each deletion (like \"rm -i\")")
+			fmt.Println("quit                - stop cleaning")
+			fmt.Println("help                - this screen")
+		default:
+			fmt.Printf("Huh (%s)?\n", choice)
+		}
+	}
+
+	return targets, nil
+}
+
+// cleanParseSelection parses a list such as "1 3-5 *" into the selected targets.
+func cleanParseSelection(selection string, targets []string) ([]string, error) {
+	chosen := make([]bool, len(targets))
+	for _, field := range strings.FieldsFunc(selection, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if field == "*" {
+			for i := range chosen {
+				chosen[i] = true
+			}
+			continue
+		}
+
+		lo, hi, isRange := strings.Cut(field, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("Huh (%s)?", field)
+		}
+		end := start
+		if isRange {
+			end = len(targets)
+			if len(hi) != 0 {
+				if end, err = strconv.Atoi(hi); err != nil {
+					return nil, fmt.Errorf("Huh (%s)?", field)
+				}
+			}
+		}
+		for i := max(start, 1); i <= min(end, len(targets)); i++ {
+			chosen[i-1] = true
+		}
+	}
+
+	ret := make([]string, 0)
+	for i, t := range targets {
+		if chosen[i] {
+			ret = append(ret, t)
+		}
+	}
+	return ret, nil
+}
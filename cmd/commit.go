@@ -1,32 +1,74 @@
 package cmd
 
 import (
+	"bytes"
+	"cmp"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/kbraun9118/wyog/repository"
-	"github.com/kbraun9118/wyog/util"
 	"github.com/spf13/cobra"
 )
 
 func init() {
-	commitCmd.Flags().StringVarP(&message, "message", "m", "", "Message to associate with this commit")
-	commitCmd.MarkFlagRequired("message")
+	commitCmd.Flags().StringArrayVarP(&commitMessages, "message", "m", nil, "Use the given message as a paragraph of the commit message")
+	commitCmd.Flags().StringVarP(&commitFile, "file", "F", "", "Take the commit message from the given file, - for stdin")
+	commitCmd.Flags().StringVarP(&commitTemplate, "template", "t", "", "Start the editor with the contents of the given file")
+	commitCmd.Flags().BoolVarP(&commitEdit, "edit", "e", false, "Edit the message given with -m or -F")
+	commitCmd.Flags().BoolVar(&commitNoEdit, "no-edit", false, "Use the message of the amended commit without launching the editor")
+	commitCmd.Flags().StringVar(&commitCleanup, "cleanup", "", "How to clean up the message: strip, whitespace, verbatim, scissors or default")
+	commitCmd.Flags().StringVar(&commitAuthor, "author", "", "Override the commit author, given as 'Name <email>'")
+	commitCmd.Flags().StringVar(&commitDate, "date", "", "Override the author date")
+	commitCmd.Flags().BoolVar(&commitAmend, "amend", false, "Replace the tip of the current branch with a new commit")
+	commitCmd.Flags().BoolVar(&commitResetAuthor, "reset-author", false, "With --amend, become the author of the commit")
+	commitCmd.Flags().BoolVarP(&commitAll, "all", "a", false, "Stage modified and deleted tracked files first")
+	commitCmd.Flags().BoolVar(&commitAllowEmpty, "allow-empty", false, "Allow a commit with the same tree as its parent")
+	commitCmd.Flags().BoolVarP(&commitOnly, "only", "o", false, "Commit only the given paths, ignoring other staged changes")
+	commitCmd.Flags().BoolVarP(&commitInclude, "include", "i", false, "Stage the given paths and commit them with the rest of the index")
+	commitCmd.Flags().StringVar(&commitFixup, "fixup", "", "Make a commit to be folded into <commit> by rebase --autosquash")
+	commitCmd.Flags().StringVar(&commitSquash, "squash", "", "Make a commit to be squashed into <commit> by rebase --autosquash")
+	commitCmd.Flags().BoolVarP(&commitSignoff, "signoff", "s", false, "Add a Signed-off-by trailer for the committer")
+	commitCmd.Flags().StringArrayVar(&commitTrailers, "trailer", nil, "Add a trailer, given as <token>[(=|:)<value>]")
+	commitCmd.Flags().StringVarP(&commitSigningKey, "gpg-sign", "S", "", "Sign the commit with the given key, or user.signingkey")
+	commitCmd.Flags().Lookup("gpg-sign").NoOptDefVal = defaultSigningKey
+	commitCmd.Flags().BoolVar(&commitNoSign, "no-gpg-sign", false, "Don't sign the commit even if commit.gpgSign is set")
+	commitCmd.MarkFlagsMutuallyExclusive("message", "file")
+	commitCmd.MarkFlagsMutuallyExclusive("edit", "no-edit")
+	commitCmd.MarkFlagsMutuallyExclusive("only", "include", "all")
+	commitCmd.MarkFlagsMutuallyExclusive("amend", "fixup", "squash")
+	commitCmd.MarkFlagsMutuallyExclusive("gpg-sign", "no-gpg-sign")
 }
 
 var (
-	message   string
-	commitCmd = &cobra.Command{
-		Use:   "commit",
+	commitSigningKey  string
+	commitNoSign      bool
+	commitMessages    []string
+	commitFile        string
+	commitTemplate    string
+	commitEdit        bool
+	commitNoEdit      bool
+	commitCleanup     string
+	commitAuthor      string
+	commitDate        string
+	commitAmend       bool
+	commitResetAuthor bool
+	commitAll         bool
+	commitAllowEmpty  bool
+	commitOnly        bool
+	commitInclude     bool
+	commitFixup       string
+	commitSquash      string
+	commitSignoff     bool
+	commitTrailers    []string
+	commitCmd         = &cobra.Command{
+		Use:   "commit [-a | -i | -o] [--amend] [--allow-empty] [-m <msg>... | -F <file>] [-t <file>] [-e] [--cleanup=<mode>] [--author=<author>] [--date=<date>] [--fixup | --squash <commit>] [-s] [--trailer <token>[(=|:)<value>]]... [-S[=<keyid>]] [pathspec...]",
 		Short: "Record changes to the repository.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			config, err := repository.ReadConfig()
-			if err != nil {
-				return err
-			}
-
 			path, err := repository.FindRequire(".")
 			if err != nil {
 				return err
@@ -36,78 +78,455 @@ var (
 				return err
 			}
 
-			user := config.User()
+			switch {
+			case commitAll && len(args) != 0:
+				return fmt.Errorf("paths '%s ...' with -a does not make sense", args[0])
+			case (commitOnly || commitInclude) && len(args) == 0:
+				return fmt.Errorf("No paths with --include/--only does not make sense.")
+			case commitResetAuthor && !commitAmend:
+				return fmt.Errorf("--reset-author can be used only with --amend")
+			}
 
-			head, err := repository.ObjectFind(&repo, "HEAD", "")
+			config, err := repository.ReadConfig(&repo)
 			if err != nil {
 				return err
 			}
-
-			index, err := repo.ReadIndex()
+			committer, err := committerIdent(config)
 			if err != nil {
 				return err
 			}
-			tree, err := repo.TreeFromIndex(index)
+
+			head, err := repo.Head()
 			if err != nil {
 				return err
 			}
+			parents := make([]string, 0)
+			var amended *repository.Commit
+			if commitAmend {
+				if head == "" {
+					return fmt.Errorf("You have nothing to amend.")
+				}
+				amended, err = repository.ReadCommit(&repo, head)
+				if err != nil {
+					return err
+				}
+				parents = amended.Parents()
+			} else if head != "" {
+				parents = append(parents, head)
+			}
 
-			commit, err := CreateCommit(&repo, time.Now(), tree, head, user, message)
+			var author repository.Signature
+			if amended != nil && !commitResetAuthor && commitAuthor == "" {
+				author, err = amended.Author()
+				if err == nil && commitDate != "" {
+					author.When, err = parseDate(commitDate)
+				}
+			} else {
+				author, err = authorIdent(config, commitAuthor, commitDate)
+			}
 			if err != nil {
 				return err
 			}
 
-			activeBranch, err := repo.ActiveBranch()
+			lock, index, err := repo.LockIndex()
 			if err != nil {
 				return err
 			}
-			var file *string
-			if len(activeBranch) != 0 {
+			defer lock.Rollback()
 
-				file, err = repo.File("refs", "heads", activeBranch)
+			// the index that is committed, which differs from the one that is written back
+			// when only some paths are committed
+			committed := index
+			switch {
+			case commitAll:
+				if _, err := stageTracked(&repo, index, nil); err != nil {
+					return err
+				}
+			case len(args) != 0:
+				spec, err := repo.ParsePathspec(args)
 				if err != nil {
 					return err
 				}
-			} else {
-				file, err = repo.File("HEAD")
+				committed, err = stagePaths(&repo, index, spec, head, !commitInclude)
 				if err != nil {
 					return err
 				}
 			}
-			os.WriteFile(*file, []byte(commit+"\n"), 0644)
+			if len(committed.Unmerged()) != 0 {
+				return fmt.Errorf("Committing is not possible because you have unmerged files.")
+			}
+
+			tree, err := repo.TreeFromIndex(committed)
+			if err != nil {
+				return err
+			}
+			if !commitAllowEmpty && !commitAmend {
+				if err := checkEmptyCommit(&repo, index, tree, head); err != nil {
+					return err
+				}
+			}
+
+			message, err := commitMessage(&repo, config, committed, amended, committer)
+			if err != nil {
+				return err
+			}
 
-			return nil
+			if committed != index {
+				if _, err := repo.TreeFromIndex(index); err != nil {
+					return err
+				}
+			}
+
+			signingKey := ""
+			if cmd.Flags().Changed("gpg-sign") {
+				signingKey = commitSigningKey
+			} else if sign, _ := strconv.ParseBool(config.Get("commit", "gpgsign")); sign && !commitNoSign {
+				signingKey = defaultSigningKey
+			}
+			commit, err := CreateCommit(&repo, config, tree, parents, author, committer, message, signingKey)
+			if err != nil {
+				return err
+			}
+
+			if err := repo.UpdateHead(commit); err != nil {
+				return err
+			}
+			// only now is the index written, keeping the fresh trees cached for the next
+			// commit; on any earlier error the deferred rollback leaves it untouched
+			return repo.WriteIndex(lock, index)
 		},
 	}
 )
 
+// CreateCommit writes a commit, signed with signingKey unless it is empty.
 func CreateCommit(
 	repo *repository.Repository,
-	timestamp time.Time,
-	tree, parent, author, message string,
+	config *repository.Config,
+	tree string,
+	parents []string,
+	author, committer repository.Signature,
+	message, signingKey string,
 ) (string, error) {
-	commit := repository.Commit{
-		Kvlm: repository.KvlmData{
-			LinkedMap: util.NewLinkedMap[string, []string](),
-		},
+	commit, err := repository.BuildCommit(tree, parents, author, committer, message)
+	if err != nil {
+		return "", err
+	}
+	if signingKey != "" {
+		sig, err := signPayload(config, signingKey, commit.Serialize())
+		if err != nil {
+			return "", err
+		}
+		commit.SetSignature(sig)
+	}
+	return repository.Write(commit, repo)
+}
+
+// stageTracked updates the index with the worktree contents of the tracked files selected
+// by spec, dropping the ones that were deleted. It returns the selected paths.
+func stageTracked(repo *repository.Repository, index *repository.Index, spec *repository.Pathspec) ([]string, error) {
+	paths := make([]string, 0)
+	for _, e := range index.Entries {
+		if spec.Match(e.Name) && !slices.Contains(paths, e.Name) {
+			paths = append(paths, e.Name)
+		}
+	}
+
+	for _, path := range paths {
+		old := index.Entries[slices.IndexFunc(index.Entries, func(e repository.IndexEntry) bool {
+			return e.Name == path
+		})]
+		if old.SkipWorktree {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(repo.Worktree, path)); err != nil {
+			removeIndexEntry(index, path)
+			continue
+		}
+		if old.Stage == 0 && !old.IntentToAdd {
+			changed, err := repo.EntryChanged(index, old)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				continue
+			}
+		}
+
+		sha, err := repo.HashFile(path, true)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := repo.IndexEntryFromFile(path, sha)
+		if err != nil {
+			return nil, err
+		}
+		if old.Stage == 0 {
+			entry.SetMode(repo.StageMode(entry.Mode(), old.Mode()))
+		}
+		setIndexEntry(index, entry)
+	}
+	return paths, nil
+}
+
+// stagePaths stages the tracked files selected by spec. With only, it returns a separate
+// index holding HEAD with just those paths updated, so that other staged changes are
+// left out of the commit.
+func stagePaths(repo *repository.Repository, index *repository.Index, spec *repository.Pathspec, head string, only bool) (*repository.Index, error) {
+	tree, err := commitDict(repo, head)
+	if err != nil {
+		return nil, err
+	}
+	known := slices.Collect(maps.Keys(tree))
+	for _, e := range index.Entries {
+		known = append(known, e.Name)
+	}
+	if unmatched := spec.Unmatched(known); len(unmatched) != 0 {
+		return nil, fmt.Errorf("pathspec '%s' did not match any file(s) known to wyog", unmatched[0])
+	}
+
+	if _, err := stageTracked(repo, index, spec); err != nil {
+		return nil, err
+	}
+	if !only {
+		return index, nil
+	}
+
+	modes, err := commitModes(repo, head)
+	if err != nil {
+		return nil, err
+	}
+	committed, err := repo.IndexFromDict(tree, modes)
+	if err != nil {
+		return nil, err
 	}
-	commit.Kvlm.Set("tree", []string{tree})
+	committed.Entries = slices.DeleteFunc(committed.Entries, func(e repository.IndexEntry) bool {
+		return spec.Match(e.Name)
+	})
+	for _, e := range index.Entries {
+		if spec.Match(e.Name) {
+			committed.Entries = append(committed.Entries, e)
+		}
+	}
+	committed.Sort()
+	return committed, nil
+}
 
-	if len(parent) != 0 {
-		commit.Kvlm.Set("parent", []string{parent})
+// checkEmptyCommit refuses a commit that records the same tree as its parent, or an
+// empty tree as the first commit.
+func checkEmptyCommit(repo *repository.Repository, index *repository.Index, tree, head string) error {
+	parentTree, err := repository.Write(&repository.Tree{}, nil)
+	if head != "" {
+		parentTree, err = repository.ObjectFind(repo, head, "tree")
+	}
+	if err != nil {
+		return err
+	}
+	if tree != parentTree {
+		return nil
 	}
 
-	message = strings.ReplaceAll(message, "\n", "")
-	author = fmt.Sprintf("%s %d %s", author, timestamp.Unix(), timestamp.Format("-0700"))
+	changes, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return err
+	}
+	if len(changes) != 0 {
+		return fmt.Errorf("no changes added to commit (use \"wyog add\" and/or \"wyog commit -a\")")
+	}
+	return fmt.Errorf("nothing to commit, working tree clean")
+}
+
+const commitHint = `Please enter the commit message for your changes. Lines starting
+with '#' will be ignored, and an empty message aborts the commit.`
 
-	commit.Kvlm.Set("author", []string{author})
-	commit.Kvlm.Set("committer", []string{author})
-	commit.Kvlm.Message = []byte(message)
+const commitKeepHint = `Please enter the commit message for your changes. Lines starting
+with '#' will be kept; you may remove them yourself if you want to.
+An empty message aborts the commit.`
 
-	sha, err := repository.Write(&commit, repo)
+const scissorsHint = `Do not modify or remove the line above.
+Everything below it will be ignored.`
+
+// commitMessage takes the message from -m or -F, or lets the user write it in the editor,
+// starting from the template or the amended message and followed by a commented summary
+// of the status.
+func commitMessage(
+	repo *repository.Repository,
+	config *repository.Config,
+	index *repository.Index,
+	amended *repository.Commit,
+	committer repository.Signature,
+) (string, error) {
+	mode := cmp.Or(commitCleanup, config.Get("commit", "cleanup"), "default")
+	if _, err := cleanupMessage("", mode, false); err != nil {
+		return "", err
+	}
+
+	edit := (commitEdit || len(commitMessages) == 0 && commitFile == "") && !commitNoEdit
+	var message, template string
+	switch {
+	case len(commitMessages) != 0:
+		message = strings.Join(commitMessages, "\n\n")
+	case commitFile != "":
+		data, err := readMessageFile(commitFile)
+		if err != nil {
+			return "", err
+		}
+		message = string(data)
+	case amended != nil:
+		message = amended.Message()
+	case commitFixup != "":
+		edit = commitEdit
+	case commitSquash != "":
+	default:
+		if path := cmp.Or(commitTemplate, config.Get("commit", "template")); path != "" {
+			path = repository.ExpandPath(path)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return "", fmt.Errorf("could not read '%s'", path)
+			}
+			template = string(data)
+		}
+		message = template
+	}
+
+	if target := cmp.Or(commitFixup, commitSquash); target != "" {
+		sha, err := repository.ObjectFind(repo, target, "commit")
+		if err != nil {
+			return "", err
+		}
+		commit, err := repository.ReadCommit(repo, sha)
+		if err != nil {
+			return "", err
+		}
+		prefix := "squash! "
+		if commitFixup != "" {
+			prefix = "fixup! "
+		}
+		message = strings.TrimSuffix(prefix+commit.Subject()+"\n\n"+message, "\n")
+	}
+
+	if commitSignoff || len(commitTrailers) != 0 {
+		var err error
+		message, err = commitAddTrailers(config, message, committer)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if edit {
+		text, err := commitEditText(repo, index, message, mode)
+		if err != nil {
+			return "", err
+		}
+		message, err = editText(repo, "COMMIT_EDITMSG", text)
+		if err != nil {
+			return "", err
+		}
+	}
+	message, err := cleanupMessage(message, mode, edit)
 	if err != nil {
 		return "", err
 	}
 
-	return sha, nil
+	if template != "" {
+		cleaned, _ := cleanupMessage(template, mode, edit)
+		if rest, ok := strings.CutPrefix(message, cleaned); ok && onlySignoffs(rest) {
+			return "", fmt.Errorf("Aborting commit; you did not edit the message.")
+		}
+	}
+	// comments only count towards a message that is kept verbatim
+	if mode == "verbatim" && message == "" || mode != "verbatim" && onlySignoffs(stripspace(message, true)) {
+		return "", fmt.Errorf("Aborting commit due to empty commit message.")
+	}
+	return message, nil
+}
+
+// commitAddTrailers adds the --trailer trailers to the message, after a Signed-off-by
+// for the committer with --signoff.
+func commitAddTrailers(config *repository.Config, message string, committer repository.Signature) (string, error) {
+	opts, err := trailerOptions(config)
+	if err != nil {
+		return "", err
+	}
+	trailers, err := parseTrailerArgs(opts, commitTrailers)
+	if err != nil {
+		return "", err
+	}
+
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	block := repository.ParseTrailers(message, opts)
+	if commitSignoff {
+		signoff := repository.Trailer{Token: "Signed-off-by", Value: fmt.Sprintf("%s <%s>", committer.Name, committer.Email)}
+		block.Add(signoff, "addIfDifferentNeighbor")
+	}
+	for _, trailer := range trailers {
+		block.Add(trailer, "")
+	}
+	return block.String(), nil
+}
+
+// onlySignoffs reports whether a message has nothing but blank lines and Signed-off-by
+// trailers, which don't make a message on their own.
+func onlySignoffs(message string) bool {
+	for _, line := range strings.Split(message, "\n") {
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "Signed-off-by: ") {
+			return false
+		}
+	}
+	return true
+}
+
+// commitEditText is what the editor starts with: the message so far, a hint on how it
+// is cleaned up and the status as comments.
+func commitEditText(repo *repository.Repository, index *repository.Index, message, mode string) (string, error) {
+	var status bytes.Buffer
+	if err := StatusBranch(&status, repo); err != nil {
+		return "", err
+	}
+	if err := StatusHeadIndex(&status, repo, index, nil); err != nil {
+		return "", err
+	}
+	if err := StatusIndexWorktree(&status, repo, index, nil); err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	text.WriteString(message)
+	if message == "" || !strings.HasSuffix(message, "\n") {
+		text.WriteString("\n")
+	}
+	text.WriteString("\n")
+
+	hint := commitHint
+	switch mode {
+	case "scissors":
+		hint = scissors + "\n" + scissorsHint
+	case "whitespace", "verbatim":
+		hint = commitKeepHint
+	}
+	for _, line := range strings.Split(hint+"\n\n"+strings.TrimRight(status.String(), "\n"), "\n") {
+		if line == "" {
+			text.WriteString("#\n")
+		} else if strings.HasPrefix(line, "#") {
+			text.WriteString(line + "\n")
+		} else {
+			text.WriteString("# " + line + "\n")
+		}
+	}
+	return text.String(), nil
+}
+
+func WriteCommit(
+	repo *repository.Repository,
+	tree string,
+	parents []string,
+	author, committer repository.Signature,
+	message string,
+) (string, error) {
+	commit, err := repository.BuildCommit(tree, parents, author, committer, message)
+	if err != nil {
+		return "", err
+	}
+	return repository.Write(commit, repo)
 }
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+func TestFailedCommitKeepsIndex(t *testing.T) {
+	repo := testRepo(t)
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := repository.Write(repository.NewBlob([]byte("a\n")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := os.OpenFile(repo.Path("config"), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = config.WriteString("[commit]\n\tgpgsign = true\n[gpg]\n\tformat = bogus\n")
+	config.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("a", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = wyog(t, "commit", "-a", "-m", "b")
+	commitAll = false
+	if err == nil || !strings.Contains(err.Error(), "gpg.format") {
+		t.Fatalf("got error %v, want unsupported gpg.format", err)
+	}
+
+	if now, err := repo.Head(); err != nil || now != head {
+		t.Errorf("failed commit moved HEAD to %q", now)
+	}
+	index, err := repo.ReadIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha := index.Dict()["a"]; sha != staged {
+		t.Errorf("failed commit staged a as %s, want %s", sha, staged)
+	}
+	if _, err := os.Stat(repo.Path("index.lock")); err == nil {
+		t.Errorf("failed commit left index.lock behind")
+	}
+}
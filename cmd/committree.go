@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	commitTreeCmd.Flags().StringArrayVarP(&commitTreeParents, "parent", "p", nil, "Id of a parent commit object")
+	commitTreeCmd.Flags().StringArrayVarP(&commitTreeMessages, "message", "m", nil, "A paragraph in the commit log message")
+	commitTreeCmd.Flags().StringArrayVarP(&commitTreeFiles, "file", "F", nil, "Read the commit log message from the given file, - for stdin")
+}
+
+var (
+	commitTreeParents  []string
+	commitTreeMessages []string
+	commitTreeFiles    []string
+	commitTreeCmd      = &cobra.Command{
+		Use:   "commit-tree <tree> [-p <parent>]... [-m <message>]... [-F <file>]...",
+		Short: "Create a new commit object.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			config, err := repository.ReadConfig(&repo)
+			if err != nil {
+				return err
+			}
+			author, err := authorIdent(config, "", "")
+			if err != nil {
+				return err
+			}
+			committer, err := committerIdent(config)
+			if err != nil {
+				return err
+			}
+
+			tree, err := repository.ObjectFind(&repo, args[0], "tree")
+			if err != nil {
+				return fmt.Errorf("not a valid object name %s", args[0])
+			}
+			if tree == "" {
+				return fmt.Errorf("%s is not a valid 'tree' object", args[0])
+			}
+
+			parents := make([]string, 0, len(commitTreeParents))
+			for _, p := range commitTreeParents {
+				parent, err := repository.ObjectFind(&repo, p, "commit")
+				if err != nil {
+					return fmt.Errorf("not a valid object name %s", p)
+				}
+				if parent == "" {
+					return fmt.Errorf("%s is not a valid 'commit' object", p)
+				}
+				if slices.Contains(parents, parent) {
+					fmt.Fprintf(os.Stderr, "error: duplicate parent %s ignored\n", parent)
+					continue
+				}
+				parents = append(parents, parent)
+			}
+
+			message, err := commitTreeMessage()
+			if err != nil {
+				return err
+			}
+
+			sha, err := WriteCommit(&repo, tree, parents, author, committer, message)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(sha)
+			return nil
+		},
+	}
+)
+
+// commitTreeMessage joins the -m paragraphs followed by the -F files, reading the
+// message from stdin when there are none.
+func commitTreeMessage() (string, error) {
+	if len(commitTreeMessages) == 0 && len(commitTreeFiles) == 0 {
+		data, err := readMessageFile("-")
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+
+	paragraphs := make([]string, 0, len(commitTreeMessages)+len(commitTreeFiles))
+	for _, m := range commitTreeMessages {
+		paragraphs = append(paragraphs, m+"\n")
+	}
+	for _, f := range commitTreeFiles {
+		data, err := readMessageFile(f)
+		if err != nil {
+			return "", err
+		}
+		paragraphs = append(paragraphs, string(data))
+	}
+
+	return strings.Join(paragraphs, "\n"), nil
+}
+
+func readMessageFile(path string) ([]byte, error) {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("could not read log from standard input")
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read log file '%s'", path)
+	}
+	return data, nil
+}
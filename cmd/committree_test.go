@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+func TestCommitTreeOfBlobFails(t *testing.T) {
+	repo := testRepo(t)
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repository.ReadCommit(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := repository.Write(repository.NewBlob([]byte("a\n")), repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"commit-tree", blob, "-m", "x"}, blob + " is not a valid 'tree' object"},
+		{[]string{"commit-tree", commit.Tree(), "-p", blob, "-m", "x"}, blob + " is not a valid 'commit' object"},
+	} {
+		err := wyog(t, tt.args...)
+		commitTreeParents, commitTreeMessages = nil, nil
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("wyog %s: got error %v, want %s", strings.Join(tt.args, " "), err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+func editorCommand(repo *repository.Repository) string {
+	if editor, ok := os.LookupEnv("GIT_EDITOR"); ok && editor != "" {
+		return editor
+	}
+	if config, err := repository.ReadConfig(repo); err == nil {
+		if editor := config.Get("core", "editor"); editor != "" {
+			return editor
+		}
+	}
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor, ok := os.LookupEnv(env); ok && editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+func launchEditor(repo *repository.Repository, path string) error {
+	editor := editorCommand(repo)
+	if editor == ":" {
+		return nil
+	}
+
+	c := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s'", editor)
+	}
+	return nil
+}
+
+func editText(repo *repository.Repository, file, text string) (string, error) {
+	path, err := repo.FileMk(strings.Split(file, "/")...)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(*path, []byte(text), 0644); err != nil {
+		return "", fmt.Errorf("cannot write %s", file)
+	}
+	if err := launchEditor(repo, *path); err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(*path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read %s", file)
+	}
+	return string(edited), nil
+}
+
+func stripComments(message string) string {
+	return stripspace(message, true)
+}
+
+const scissors = "# ------------------------ >8 ------------------------"
+
+// stripspace removes trailing whitespace, collapses runs of blank lines and drops blank
+// lines at either end, and with comments also removes lines starting with '#'. A
+// non-empty result ends in a newline.
+func stripspace(message string, comments bool) string {
+	lines := make([]string, 0)
+	blank := false
+	for _, line := range strings.Split(message, "\n") {
+		if comments && strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) != 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// cleanupMessage applies a --cleanup mode to a commit message. The default mode strips
+// comments from messages that went through the editor and only whitespace otherwise.
+func cleanupMessage(message, mode string, edited bool) (string, error) {
+	if mode == "default" {
+		mode = "whitespace"
+		if edited {
+			mode = "strip"
+		}
+	}
+
+	switch mode {
+	case "strip":
+		return stripspace(message, true), nil
+	case "whitespace":
+		return stripspace(message, false), nil
+	case "scissors":
+		if edited {
+			if i := strings.Index(message, scissors+"\n"); i == 0 || i > 0 && message[i-1] == '\n' {
+				message = message[:i]
+			}
+		}
+		return stripspace(message, false), nil
+	case "verbatim":
+		if message != "" && !strings.HasSuffix(message, "\n") {
+			message += "\n"
+		}
+		return message, nil
+	default:
+		return "", fmt.Errorf("Invalid cleanup mode %s", mode)
+	}
+}
@@ -55,18 +55,9 @@ func ObjectHash(fd *os.File, format string, repo *repository.Repository) (string
 		return "", fmt.Errorf("cannot read file")
 	}
 
-	var obj repository.GitObject
-	switch format {
-	case "commit":
-		obj = repository.NewCommit(data)
-	case "tree":
-		obj = repository.NewTree(data)
-	case "tag":
-		obj = repository.NewTag(data)
-	case "blob":
-		obj = repository.NewBlob(data)
-	default:
-		return "", fmt.Errorf("invalid type")
+	obj, err := repository.ParseObject(format, data)
+	if err != nil {
+		return "", err
 	}
 
 	return repository.Write(obj, repo)
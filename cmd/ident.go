@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+// resolveIdent looks up the author or committer identity: the name and email of override
+// win over GIT_AUTHOR_NAME and friends, which win over author.name, which wins over
+// user.name. The email falls back to $EMAIL and the date to the current time.
+func resolveIdent(config *repository.Config, role string, override repository.Signature) (repository.Signature, error) {
+	env := "GIT_" + strings.ToUpper(role) + "_"
+
+	name := cmp.Or(override.Name, os.Getenv(env+"NAME"), config.Get(role, "name"), config.Get("user", "name"))
+	email := cmp.Or(override.Email, os.Getenv(env+"EMAIL"), config.Get(role, "email"), config.Get("user", "email"), os.Getenv("EMAIL"))
+	if name == "" || email == "" {
+		return repository.Signature{}, fmt.Errorf(`%s%s identity unknown
+
+*** Please tell me who you are.
+
+Set your account's default identity in ~/.gitconfig:
+
+  [user]
+    name = Your Name
+    email = you@example.com
+
+or set %sNAME and %sEMAIL in the environment.`, strings.ToUpper(role[:1]), role[1:], env, env)
+	}
+
+	when := time.Now()
+	if date, ok := os.LookupEnv(env + "DATE"); ok {
+		var err error
+		when, err = parseDate(date)
+		if err != nil {
+			return repository.Signature{}, err
+		}
+	}
+
+	return repository.Signature{Name: name, Email: email, When: when}, nil
+}
+
+// committerIdent is the identity recorded as committer, and as tagger.
+func committerIdent(config *repository.Config) (repository.Signature, error) {
+	return resolveIdent(config, "committer", repository.Signature{})
+}
+
+var authorRe = regexp.MustCompile(`^(.*?)\s*<([^<>]*)>$`)
+
+// authorIdent resolves the author, overriding it with `--author` and `--date` when given.
+func authorIdent(config *repository.Config, author, date string) (repository.Signature, error) {
+	var override repository.Signature
+	if author != "" {
+		m := authorRe.FindStringSubmatch(author)
+		if m == nil || m[1] == "" {
+			return repository.Signature{}, fmt.Errorf("--author '%s' is not 'Name <email>'", author)
+		}
+		override.Name, override.Email = m[1], m[2]
+	}
+
+	ret, err := resolveIdent(config, "author", override)
+	if err != nil {
+		return repository.Signature{}, err
+	}
+	if date != "" {
+		ret.When, err = parseDate(date)
+		if err != nil {
+			return repository.Signature{}, err
+		}
+	}
+	return ret, nil
+}
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	time.RFC1123Z,
+	"Mon Jan 2 15:04:05 2006 -0700",
+	"2006-01-02",
+}
+
+// parseDate understands git's raw "<unix> <+hhmm>" format (optionally prefixed with @),
+// RFC 2822, ISO 8601 and RFC 3339 dates, and "now". Dates without a zone are local.
+func parseDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if date == "now" {
+		return time.Now(), nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	seconds, zone, hasZone := strings.Cut(strings.TrimPrefix(date, "@"), " ")
+	unix, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date format: %s", date)
+	}
+	t := time.Unix(unix, 0)
+	if !hasZone {
+		return t.UTC(), nil
+	}
+
+	offset, err := time.Parse("-0700", zone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date format: %s", date)
+	}
+	return t.In(offset.Location()), nil
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/kbraun9118/wyog/repository"
 	"github.com/spf13/cobra"
@@ -81,7 +82,7 @@ func repoCreate(path string) error {
 	if err != nil {
 		return fmt.Errorf("could not create config file")
 	}
-	configIni, err := defaultConfig()
+	configIni, err := defaultConfig(probeFileMode(repo.Gitdir))
 	if err != nil {
 		return fmt.Errorf("could not create config")
 	}
@@ -92,7 +93,24 @@ func repoCreate(path string) error {
 	return nil
 }
 
-func defaultConfig() (*ini.File, error) {
+// probeFileMode checks whether the filesystem keeps the executable bit, the way git
+// decides on core.filemode.
+func probeFileMode(dir string) bool {
+	file, err := os.CreateTemp(dir, "filemode")
+	if err != nil {
+		return false
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	if err := os.Chmod(file.Name(), 0755); err != nil {
+		return false
+	}
+	stat, err := os.Lstat(file.Name())
+	return err == nil && stat.Mode()&0o100 != 0
+}
+
+func defaultConfig(filemode bool) (*ini.File, error) {
 
 	configIni := ini.Empty()
 	coreSection, err := configIni.NewSection("core")
@@ -101,7 +119,7 @@ func defaultConfig() (*ini.File, error) {
 	}
 
 	coreSection.NewKey("repositoryformatversion", "0")
-	coreSection.NewKey("filemode", "false")
+	coreSection.NewKey("filemode", strconv.FormatBool(filemode))
 	coreSection.NewKey("bare", "false")
 
 	return configIni, nil
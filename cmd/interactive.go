@@ -0,0 +1,395 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+// patchMode describes one flavour of `-p`: the diff always goes from the old to the new
+// side, and a reverse mode applies the selected hunks backwards onto the new side.
+type patchMode struct {
+	reverse  bool
+	hunk     string
+	deletion string
+	addition string
+	help     string
+}
+
+var (
+	patchStage = patchMode{
+		hunk:     "Stage this hunk",
+		deletion: "Stage deletion",
+		addition: "Stage addition",
+		help:     "stage",
+	}
+	patchUnstage = patchMode{
+		reverse:  true,
+		hunk:     "Unstage this hunk",
+		deletion: "Unstage deletion",
+		addition: "Unstage addition",
+		help:     "unstage",
+	}
+	patchDiscard = patchMode{
+		reverse:  true,
+		hunk:     "Discard this hunk from worktree",
+		deletion: "Discard deletion from worktree",
+		addition: "Discard addition from worktree",
+		help:     "discard",
+	}
+)
+
+type patchUnit struct {
+	start, end  int
+	replacement []string
+	decision    int
+}
+
+const (
+	patchUndecided = iota
+	patchYes
+	patchNo
+)
+
+type patchSelector struct {
+	repo *repository.Repository
+	in   *bufio.Reader
+	mode patchMode
+	quit bool
+}
+
+func newPatchSelector(repo *repository.Repository, mode patchMode) *patchSelector {
+	return &patchSelector{repo: repo, in: bufio.NewReader(os.Stdin), mode: mode}
+}
+
+func (s *patchSelector) prompt(text string) (string, error) {
+	fmt.Print(text)
+	line, err := s.in.ReadString('\n')
+	if err != nil && len(line) == 0 {
+		return "", fmt.Errorf("no input")
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// selectFile offers a whole-file addition or deletion as a single choice.
+func (s *patchSelector) selectFile(path string, deletion bool) (bool, error) {
+	if s.quit {
+		return false, nil
+	}
+
+	question := s.mode.addition
+	if deletion {
+		question = s.mode.deletion
+	}
+	fmt.Printf("diff --git a/%s b/%s\n", path, path)
+	for {
+		answer, err := s.prompt(fmt.Sprintf("%s [y,n,q,a,d,?]? ", question))
+		if err != nil {
+			return false, err
+		}
+		switch answer {
+		case "y", "a":
+			return true, nil
+		case "n", "d":
+			return false, nil
+		case "q":
+			s.quit = true
+			return false, nil
+		default:
+			s.printHelp(false)
+		}
+	}
+}
+
+// selectHunks walks the hunks of the diff from oldLines to newLines. It returns the new
+// content of the side being changed (oldLines, or newLines in reverse mode) and whether
+// any hunk was selected.
+func (s *patchSelector) selectHunks(path string, oldLines, newLines []string) ([]byte, bool, error) {
+	edits := repository.DiffLines(oldLines, newLines)
+	units := make([]*patchUnit, 0)
+	for _, h := range repository.Hunks(edits, diffContext) {
+		start := slices.Index(edits, h.Edits[0])
+		units = append(units, &patchUnit{start: start, end: start + len(h.Edits)})
+	}
+	if len(units) == 0 || s.quit {
+		return nil, false, nil
+	}
+
+	fmt.Printf("diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", path, path, path, path)
+
+	for i := 0; i < len(units); {
+		u := units[i]
+		if u.decision != patchUndecided {
+			i++
+			continue
+		}
+
+		hunk := repository.NewHunk(edits[u.start:u.end])
+		fmt.Print(hunk.String())
+
+		splittable := len(patchGroups(edits, u.start, u.end)) > 1
+		choices := "y,n,q,a,d"
+		if splittable {
+			choices += ",s"
+		}
+		choices += ",e,?"
+
+		answer, err := s.prompt(fmt.Sprintf("(%d/%d) %s [%s]? ", i+1, len(units), s.mode.hunk, choices))
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch answer {
+		case "y":
+			u.decision = patchYes
+		case "n":
+			u.decision = patchNo
+		case "q":
+			s.quit = true
+			for _, rest := range units[i:] {
+				if rest.decision == patchUndecided {
+					rest.decision = patchNo
+				}
+			}
+		case "a", "d":
+			decision := patchYes
+			if answer == "d" {
+				decision = patchNo
+			}
+			for _, rest := range units[i:] {
+				if rest.decision == patchUndecided {
+					rest.decision = decision
+				}
+			}
+		case "s":
+			if !splittable {
+				fmt.Println("Sorry, cannot split this hunk")
+				continue
+			}
+			parts := patchSplit(edits, u)
+			fmt.Printf("Split into %d hunks.\n", len(parts))
+			units = slices.Concat(units[:i], parts, units[i+1:])
+		case "e":
+			// split parts share their context, and patchApply writes an edited part over its
+			// whole range, so two edits must not overlap
+			if slices.ContainsFunc(units, func(other *patchUnit) bool {
+				return other != u && other.replacement != nil && other.start < u.end && u.start < other.end
+			}) {
+				fmt.Println("Sorry, cannot edit this hunk, it overlaps a hunk that was already edited")
+				continue
+			}
+			replacement, err := s.editHunk(hunk)
+			if err != nil {
+				return nil, false, err
+			}
+			if replacement != nil {
+				u.replacement = replacement
+				u.decision = patchYes
+			}
+		default:
+			s.printHelp(true)
+		}
+	}
+
+	return patchApply(edits, units, s.mode.reverse)
+}
+
+func (s *patchSelector) printHelp(hunks bool) {
+	verb := s.mode.help
+	fmt.Printf("y - %s this hunk\n", verb)
+	fmt.Printf("n - do not %s this hunk\n", verb)
+	fmt.Printf("q - quit; do not %s this hunk or any of the remaining ones\n", verb)
+	fmt.Printf("a - %s this hunk and all later hunks in the file\n", verb)
+	fmt.Printf("d - do not %s this hunk or any of the later hunks in the file\n", verb)
+	if hunks {
+		fmt.Println("s - split the current hunk into smaller hunks")
+		fmt.Println("e - manually edit the current hunk")
+	}
+	fmt.Println("? - print help")
+}
+
+// patchGroups returns the [start, end) ranges of consecutive changes within edits[start:end].
+func patchGroups(edits []repository.Edit, start, end int) [][2]int {
+	groups := make([][2]int, 0)
+	for i := start; i < end; i++ {
+		if edits[i].Kind == repository.EditEqual {
+			continue
+		}
+		if len(groups) != 0 && groups[len(groups)-1][1] == i {
+			groups[len(groups)-1][1] = i + 1
+		} else {
+			groups = append(groups, [2]int{i, i + 1})
+		}
+	}
+	return groups
+}
+
+// patchSplit breaks a hunk at its context lines. Neighbouring parts share the context
+// between them, as each only owns its own changes.
+func patchSplit(edits []repository.Edit, u *patchUnit) []*patchUnit {
+	groups := patchGroups(edits, u.start, u.end)
+	parts := make([]*patchUnit, 0, len(groups))
+	for i := range groups {
+		start, end := u.start, u.end
+		if i > 0 {
+			start = groups[i-1][1]
+		}
+		if i < len(groups)-1 {
+			end = groups[i+1][0]
+		}
+		parts = append(parts, &patchUnit{start: start, end: end})
+	}
+	return parts
+}
+
+const patchEditHelp = `# ---
+# To remove '%c' lines, make them ' ' lines (context).
+# To remove '%c' lines, delete them.
+# Lines starting with # will be removed.
+# If the patch applies cleanly, the edited hunk will immediately be marked to %s.
+# If it does not apply cleanly, you will be given an opportunity to
+# edit again.  If all lines of the hunk are removed, then the edit is
+# aborted and the hunk is left unchanged.
+`
+
+// editHunk lets the user rewrite a hunk and returns the lines that replace the hunk's
+// range on the side being changed, or nil if the edit was abandoned.
+func (s *patchSelector) editHunk(hunk repository.Hunk) ([]string, error) {
+	// the side being changed must still match, the other side is what it becomes
+	keep, result := byte('-'), byte('+')
+	if s.mode.reverse {
+		keep, result = result, keep
+	}
+
+	text := "# Manual hunk edit mode -- see bottom for a quick guide.\n" +
+		hunk.String() +
+		fmt.Sprintf(patchEditHelp, keep, result, s.mode.help)
+
+	for {
+		edited, err := editText(s.repo, "ADD_EDIT.patch", text)
+		if err != nil {
+			return nil, err
+		}
+
+		base, replacement, empty := parseEditedHunk(edited, keep, result)
+		if empty {
+			return nil, nil
+		}
+
+		expected := make([]string, 0, len(hunk.Edits))
+		for _, e := range hunk.Edits {
+			if e.Kind == repository.EditEqual || (e.Kind == repository.EditDelete) != s.mode.reverse {
+				expected = append(expected, e.Text)
+			}
+		}
+		if slices.Equal(base, expected) {
+			return replacement, nil
+		}
+
+		answer, err := s.prompt("Your edited hunk does not apply. Edit again (saying \"no\" discards!) [y/n]? ")
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(answer, "y") {
+			return nil, nil
+		}
+		text = edited
+	}
+}
+
+// parseEditedHunk splits an edited hunk into the lines it expects to find and the lines
+// it leaves behind.
+func parseEditedHunk(text string, keep, result byte) ([]string, []string, bool) {
+	base := make([]string, 0)
+	replacement := make([]string, 0)
+	empty := true
+
+	var last byte
+	for _, line := range repository.SplitLines([]byte(text)) {
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "@@") {
+			continue
+		}
+		if strings.HasPrefix(line, `\`) {
+			if last == ' ' || last == keep {
+				base[len(base)-1] = strings.TrimSuffix(base[len(base)-1], "\n")
+			}
+			if last == ' ' || last == result {
+				replacement[len(replacement)-1] = strings.TrimSuffix(replacement[len(replacement)-1], "\n")
+			}
+			continue
+		}
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if line == "\n" {
+			line = " \n"
+		}
+
+		empty = false
+		last = line[0]
+		body := line[1:]
+		switch last {
+		case ' ':
+			base = append(base, body)
+			replacement = append(replacement, body)
+		case keep:
+			base = append(base, body)
+		case result:
+			replacement = append(replacement, body)
+		}
+	}
+
+	return base, replacement, empty
+}
+
+// patchApply rebuilds the side being changed with the chosen hunks applied.
+func patchApply(edits []repository.Edit, units []*patchUnit, reverse bool) ([]byte, bool, error) {
+	selected := make(map[int]bool)
+	replaced := make(map[int]*patchUnit)
+	changed := false
+	for _, u := range units {
+		if u.decision != patchYes {
+			continue
+		}
+		changed = true
+		if u.replacement != nil {
+			replaced[u.start] = u
+			continue
+		}
+		for i := u.start; i < u.end; i++ {
+			selected[i] = true
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(edits); {
+		if u, ok := replaced[i]; ok {
+			b.WriteString(strings.Join(u.replacement, ""))
+			i = u.end
+			continue
+		}
+
+		e := edits[i]
+		switch {
+		case e.Kind == repository.EditEqual:
+			b.WriteString(e.Text)
+		case (e.Kind == repository.EditInsert) != reverse:
+			// a line only on the target side of the diff
+			if selected[i] {
+				b.WriteString(e.Text)
+			}
+		default:
+			// a line only on the side being changed
+			if !selected[i] {
+				b.WriteString(e.Text)
+			}
+		}
+		i++
+	}
+
+	return []byte(b.String()), changed, nil
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	interpretTrailersCmd.Flags().StringArrayVar(&interpretTrailers, "trailer", nil, "Add a trailer, given as <token>[(=|:)<value>]")
+	interpretTrailersCmd.Flags().StringVar(&interpretIfExists, "if-exists", "", "What to do if a trailer with the same token exists: addIfDifferentNeighbor, addIfDifferent, add, replace or doNothing")
+	interpretTrailersCmd.Flags().BoolVar(&interpretParse, "parse", false, "Only print the trailers of the input, with continuation lines joined")
+}
+
+var (
+	interpretTrailers    []string
+	interpretIfExists    string
+	interpretParse       bool
+	interpretTrailersCmd = &cobra.Command{
+		Use:   "interpret-trailers [--trailer <token>[(=|:)<value>]]... [--if-exists <action>] [--parse] [<file>...]",
+		Short: "Add or parse structured information in commit messages.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if interpretParse && len(interpretTrailers) != 0 {
+				return fmt.Errorf("--trailer with --parse does not make sense")
+			}
+			if interpretIfExists != "" {
+				if err := repository.CheckIfExists(interpretIfExists); err != nil {
+					return err
+				}
+			}
+
+			// trailers can be interpreted outside of a repository, with the global config
+			var repo *repository.Repository
+			if path := repository.Find("."); path != nil {
+				r, err := repository.New(*path)
+				if err != nil {
+					return err
+				}
+				repo = &r
+			}
+			config, err := repository.ReadConfig(repo)
+			if err != nil {
+				return err
+			}
+			opts, err := trailerOptions(config)
+			if err != nil {
+				return err
+			}
+			trailers, err := parseTrailerArgs(opts, interpretTrailers)
+			if err != nil {
+				return err
+			}
+
+			inputs := make([][]byte, 0, len(args))
+			if len(args) == 0 {
+				data, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return fmt.Errorf("could not read from stdin")
+				}
+				inputs = append(inputs, data)
+			}
+			for _, arg := range args {
+				data, err := os.ReadFile(arg)
+				if err != nil {
+					return fmt.Errorf("could not read input file '%s'", arg)
+				}
+				inputs = append(inputs, data)
+			}
+
+			for _, input := range inputs {
+				block := repository.ParseTrailers(string(input), opts)
+				if interpretParse {
+					fmt.Print(block.Parsed())
+					continue
+				}
+				for _, trailer := range trailers {
+					block.Add(trailer, interpretIfExists)
+				}
+				fmt.Print(block.String())
+			}
+			return nil
+		},
+	}
+)
+
+// trailerOptions reads trailer.separators, trailer.ifexists and the [trailer "<name>"]
+// sections from the config.
+func trailerOptions(config *repository.Config) (repository.TrailerOptions, error) {
+	opts := repository.TrailerOptions{
+		Separators: config.Get("trailer", "separators"),
+		IfExists:   config.Get("trailer", "ifexists"),
+	}
+	for _, name := range config.Subsections("trailer") {
+		opts.Configured = append(opts.Configured, repository.ConfiguredTrailer{
+			Name:     name,
+			Key:      config.Get("trailer."+name, "key"),
+			IfExists: config.Get("trailer."+name, "ifexists"),
+		})
+	}
+
+	for _, c := range append(opts.Configured, repository.ConfiguredTrailer{IfExists: opts.IfExists}) {
+		if c.IfExists == "" {
+			continue
+		}
+		if err := repository.CheckIfExists(c.IfExists); err != nil {
+			return repository.TrailerOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+func parseTrailerArgs(opts repository.TrailerOptions, args []string) ([]repository.Trailer, error) {
+	trailers := make([]repository.Trailer, 0, len(args))
+	for _, arg := range args {
+		trailer, err := repository.ParseTrailerArg(arg, opts)
+		if err != nil {
+			return nil, err
+		}
+		trailers = append(trailers, trailer)
+	}
+	return trailers, nil
+}
@@ -8,8 +8,14 @@ import (
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	logCmd.Flags().BoolVar(&logShowSignature, "show-signature", false, "Check the signature of signed commits and add the result to their labels")
+}
+
+var logShowSignature bool
+
 var logCmd = &cobra.Command{
-	Use:   "log [commit]",
+	Use:   "log [--show-signature] [commit]",
 	Short: "Display history of a given commit.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		r, err := repository.FindRequire(".")
@@ -34,7 +40,14 @@ var logCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		err = logGraphviz(&repo, obj, make(map[string]bool))
+		var config *repository.Config
+		if logShowSignature {
+			config, err = repository.ReadConfig(&repo)
+			if err != nil {
+				return err
+			}
+		}
+		err = logGraphviz(&repo, config, obj, make(map[string]bool))
 		if err != nil {
 			return err
 		}
@@ -45,7 +58,9 @@ var logCmd = &cobra.Command{
 	},
 }
 
-func logGraphviz(repo *repository.Repository, sha string, seen map[string]bool) error {
+// logGraphviz prints the history of sha as graphviz nodes, with the result of checking
+// the signature of each commit when config is set.
+func logGraphviz(repo *repository.Repository, config *repository.Config, sha string, seen map[string]bool) error {
 	if seen[sha] {
 		return nil
 	}
@@ -61,23 +76,30 @@ func logGraphviz(repo *repository.Repository, sha string, seen map[string]bool)
 		return fmt.Errorf("%s is not a commit", sha)
 	}
 
-	message := strings.TrimSpace(string(commit.Kvlm.Message))
+	message := commit.Subject()
+
+	if config != nil {
+		mergeTags, _ := mergeTagResults(config, commit)
+		for _, result := range mergeTags {
+			message += "\n" + result
+		}
+		if payload, signature := commit.SignaturePayload(); len(signature) != 0 {
+			result, err := verifyPayload(config, payload, signature)
+			if err != nil {
+				result = err.Error()
+			}
+			message += "\n" + result
+		}
+	}
 	message = strings.ReplaceAll(message, "\\", "\\\\")
 	message = strings.ReplaceAll(message, "\"", "\\\"")
-
-	splits := strings.SplitN(message, "\n", 1)
-	message = splits[0]
+	message = strings.ReplaceAll(message, "\n", "\\n")
 
 	fmt.Printf("  c_%s [label=\"%s: %s\"]\n", sha, sha[:7], message)
 
-	parent, ok := commit.Kvlm.Get("parent")
-	if !ok {
-		return nil
-	}
-
-	for _, p := range parent {
+	for _, p := range commit.Parents() {
 		fmt.Printf("  c_%s -> c_%s;\n", sha, p)
-		logGraphviz(repo, p, seen)
+		logGraphviz(repo, config, p, seen)
 	}
 
 	return nil
@@ -53,29 +53,13 @@ func ls_tree(repo repository.Repository, ref string, prefix string) error {
 	}
 
 	for _, item := range treeObj.Items {
-		var itemType []byte
-		if len(item.Mode) == 5 {
-			itemType = item.Mode[0:1]
-		} else {
-			itemType = item.Mode[0:2]
-		}
-
-		var typeName string
-		switch string(itemType) {
-		case "4", "04":
-			typeName = "tree"
-		case "10":
-			typeName = "blob"
-		case "12":
-			typeName = "blob"
-		case "16":
-			typeName = "commit"
-		default:
-			return fmt.Errorf("invalid tree leaf mode %s", string(item.Mode))
+		typeName, err := item.Mode.ObjectType()
+		if err != nil {
+			return err
 		}
 
 		if !(recursive && typeName == "tree") {
-			fmt.Printf("%06s %s %s\t%s\n", item.Mode, typeName, item.Sha, filepath.Join(prefix, item.Path))
+			fmt.Printf("%s %s %s\t%s\n", item.Mode, typeName, item.Sha, filepath.Join(prefix, item.Path))
 		} else {
 			ls_tree(repo, item.Sha, filepath.Join(prefix, item.Path))
 		}
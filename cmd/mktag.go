@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+var mktagCmd = &cobra.Command{
+	Use:   "mktag",
+	Short: "Creates a tag object with extra validation.",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := repository.FindRequire(".")
+		if err != nil {
+			return err
+		}
+		repo, err := repository.New(path)
+		if err != nil {
+			return err
+		}
+
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("could not read from stdin")
+		}
+		if err := verifyTag(&repo, string(data)); err != nil {
+			return err
+		}
+
+		tag, err := repository.NewTag(data)
+		if err != nil {
+			return err
+		}
+		sha, err := repository.Write(tag, &repo)
+		if err != nil {
+			return err
+		}
+		fmt.Println(sha)
+		return nil
+	},
+}
+
+var identRe = regexp.MustCompile(`^[^<>\n]* <[^<>\n]*> [0-9]+ [+-][0-9]{4}$`)
+
+// verifyTag checks the headers of a tag object: the object, type, tag and tagger lines,
+// in that order, followed by a blank line and the message unless the tag has none.
+func verifyTag(repo *repository.Repository, data string) error {
+	headers, _, ok := strings.Cut(data, "\n\n")
+	if !ok {
+		if !strings.HasSuffix(data, "\n") {
+			return fmt.Errorf("tag input does not end its headers with a newline")
+		}
+		headers = strings.TrimSuffix(data, "\n")
+	}
+	lines := strings.Split(headers, "\n")
+
+	expected := []string{"object", "type", "tag", "tagger"}
+	values := make(map[string]string)
+	for i, key := range expected {
+		if i >= len(lines) {
+			return fmt.Errorf("tag input has no %s header", key)
+		}
+		value, ok := strings.CutPrefix(lines[i], key+" ")
+		if !ok {
+			return fmt.Errorf("tag input has no %s header", key)
+		}
+		values[key] = value
+	}
+	if len(lines) > len(expected) {
+		return fmt.Errorf("tag input has unknown header %q", lines[len(expected)])
+	}
+
+	if !repository.ValidObjectName(values["object"]) {
+		return fmt.Errorf("invalid object name %q", values["object"])
+	}
+	obj, err := repository.ReadObj(repo, values["object"])
+	if err != nil {
+		return fmt.Errorf("could not read tagged object '%s'", values["object"])
+	}
+	if actual := string(obj.Fmt()); actual != values["type"] {
+		return fmt.Errorf("object '%s' tagged as '%s', but is a '%s' type", values["object"], values["type"], actual)
+	}
+	if !validRefName("tags/" + values["tag"]) {
+		return fmt.Errorf("invalid tag name %q", values["tag"])
+	}
+	if !identRe.MatchString(values["tagger"]) {
+		return fmt.Errorf("invalid tagger line %q", values["tagger"])
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestVerifyTagWithoutMessage(t *testing.T) {
+	repo := testRepo(t)
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := "object " + head + "\ntype commit\ntag v1\ntagger Test User <test@example.com> 1700000000 +0000\n"
+
+	for _, data := range []string{headers, headers + "\n", headers + "\nmessage\n"} {
+		if err := verifyTag(repo, data); err != nil {
+			t.Errorf("verifyTag(%q) = %v", data, err)
+		}
+	}
+	if err := verifyTag(repo, headers[:len(headers)-1]); err == nil {
+		t.Errorf("verifyTag accepted an unterminated tagger line")
+	}
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mktreeCmd.Flags().BoolVarP(&mktreeNul, "nul", "z", false, "Input lines are terminated by NUL instead of LF")
+	mktreeCmd.Flags().BoolVar(&mktreeMissing, "missing", false, "Allow missing objects")
+}
+
+var (
+	mktreeNul     bool
+	mktreeMissing bool
+	mktreeCmd     = &cobra.Command{
+		Use:   "mktree [-z] [--missing]",
+		Short: "Build a tree-object from ls-tree formatted text.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			scanner := bufio.NewScanner(os.Stdin)
+			if mktreeNul {
+				scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+					if i := bytes.IndexByte(data, 0x00); i >= 0 {
+						return i + 1, data[:i], nil
+					}
+					if atEOF && len(data) != 0 {
+						return len(data), data, nil
+					}
+					return 0, nil, nil
+				})
+			}
+
+			leaves := make([]repository.TreeLeaf, 0)
+			seen := make(map[string]bool)
+			for scanner.Scan() {
+				line := scanner.Text()
+				if line == "" {
+					continue
+				}
+				leaf, err := mktreeLeaf(&repo, line)
+				if err != nil {
+					return err
+				}
+				if seen[leaf.Path] {
+					return fmt.Errorf("duplicate entry '%s' in input", leaf.Path)
+				}
+				seen[leaf.Path] = true
+				leaves = append(leaves, leaf)
+			}
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+
+			tree, err := repository.BuildTree(leaves)
+			if err != nil {
+				return err
+			}
+			sha, err := repository.Write(tree, &repo)
+			if err != nil {
+				return err
+			}
+			fmt.Println(sha)
+			return nil
+		},
+	}
+)
+
+// mktreeLeaf parses a line of `ls-tree` output: "<mode> SP <type> SP <object> TAB <path>".
+func mktreeLeaf(repo *repository.Repository, line string) (repository.TreeLeaf, error) {
+	meta, name, ok := strings.Cut(line, "\t")
+	fields := strings.Fields(meta)
+	if !ok || len(fields) != 3 {
+		return repository.TreeLeaf{}, fmt.Errorf("input format error: %s", line)
+	}
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		name = unquoted
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return repository.TreeLeaf{}, fmt.Errorf("path %s contains slash", name)
+	}
+
+	mode, kind, sha := fields[0], fields[1], strings.ToLower(fields[2])
+	if !repository.ValidObjectName(sha) {
+		return repository.TreeLeaf{}, fmt.Errorf("input format error: %s", line)
+	}
+
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return repository.TreeLeaf{}, fmt.Errorf("input format error: %s", line)
+	}
+	var expected string
+	switch value {
+	case 0o40000:
+		expected = "tree"
+	case 0o100644, 0o100755, 0o120000:
+		expected = "blob"
+	case 0o160000:
+		expected = "commit"
+	default:
+		return repository.TreeLeaf{}, fmt.Errorf("invalid mode %s in input: %s", mode, line)
+	}
+	if kind != expected {
+		return repository.TreeLeaf{}, fmt.Errorf("entry '%s' object type (%s) doesn't match mode type (%s)", name, kind, expected)
+	}
+
+	// submodule commits live in another repository
+	if !mktreeMissing && expected != "commit" {
+		obj, err := repository.ReadObj(repo, sha)
+		if err != nil {
+			return repository.TreeLeaf{}, fmt.Errorf("entry '%s' object %s is unavailable", name, sha)
+		}
+		if actual := string(obj.Fmt()); actual != expected {
+			return repository.TreeLeaf{}, fmt.Errorf("entry '%s' object %s is a %s but specified type was (%s)", name, sha, actual, kind)
+		}
+	}
+
+	return repository.TreeLeaf{Mode: repository.FileMode(value), Path: name, Sha: sha}, nil
+}
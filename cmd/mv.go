@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	mvCmd.Flags().BoolVarP(&mvForce, "force", "f", false, "Force renaming or moving of a file even if the destination exists")
+	mvCmd.Flags().BoolVarP(&mvSkipErrors, "skip-errors", "k", false, "Skip move or rename actions which would lead to an error")
+	mvCmd.Flags().BoolVarP(&mvDryRun, "dry-run", "n", false, "Only show what would happen")
+}
+
+var (
+	mvForce      bool
+	mvSkipErrors bool
+	mvDryRun     bool
+	mvCmd        = &cobra.Command{
+		Use:   "mv source... destination",
+		Short: "Move or rename a file, a directory, or a symlink.",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			sources := make([]string, 0, len(args)-1)
+			for _, arg := range args[:len(args)-1] {
+				src, err := repo.RelPath(arg)
+				if err != nil {
+					return err
+				}
+				sources = append(sources, src)
+			}
+			dest, err := repo.RelPath(args[len(args)-1])
+			if err != nil {
+				return err
+			}
+
+			return mv(&repo, sources, dest)
+		},
+	}
+)
+
+type mvMove struct {
+	src, dst string
+}
+
+func mv(repo *repository.Repository, sources []string, dest string) error {
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	destStat, destErr := os.Stat(filepath.Join(repo.Worktree, dest))
+	destIsDir := destErr == nil && destStat.IsDir()
+	if len(sources) > 1 && !destIsDir {
+		return fmt.Errorf("destination '%s' is not a directory", dest)
+	}
+
+	tracked := index.Dict()
+	moves := make([]mvMove, 0, len(sources))
+	for _, src := range sources {
+		dst := dest
+		if destIsDir {
+			dst = filepath.Join(dest, filepath.Base(src))
+		}
+
+		if err := mvCheck(repo, index, tracked, src, dst, moves); err != nil {
+			if mvSkipErrors {
+				continue
+			}
+			return fmt.Errorf("%v, source=%s, destination=%s", err, src, dst)
+		}
+		moves = append(moves, mvMove{src, dst})
+	}
+
+	for _, m := range moves {
+		if mvDryRun {
+			fmt.Printf("Checking rename of '%s' to '%s'\n", m.src, m.dst)
+			fmt.Printf("Renaming %s to %s\n", m.src, m.dst)
+			continue
+		}
+
+		srcAbs := filepath.Join(repo.Worktree, m.src)
+		dstAbs := filepath.Join(repo.Worktree, m.dst)
+		if err := os.MkdirAll(filepath.Dir(dstAbs), 0755); err != nil {
+			return fmt.Errorf("cannot create directories for %s", m.dst)
+		}
+		if err := os.Rename(srcAbs, dstAbs); err != nil {
+			return fmt.Errorf("renaming '%s' failed: %v", m.src, err)
+		}
+
+		index.Entries = slices.DeleteFunc(index.Entries, func(e repository.IndexEntry) bool {
+			return e.Name == m.dst
+		})
+		for i, e := range index.Entries {
+			if e.Name == m.src {
+				index.Entries[i].Name = m.dst
+			} else if rest, ok := strings.CutPrefix(e.Name, m.src+"/"); ok {
+				index.Entries[i].Name = filepath.Join(m.dst, rest)
+			} else {
+				continue
+			}
+			index.Invalidate(e.Name)
+			index.Invalidate(index.Entries[i].Name)
+		}
+		index.Invalidate(m.dst)
+	}
+
+	if mvDryRun {
+		return nil
+	}
+
+	index.Sort()
+	return repo.WriteIndex(lock, index)
+}
+
+func mvCheck(
+	repo *repository.Repository,
+	index *repository.Index,
+	tracked map[string]string,
+	src, dst string,
+	moves []mvMove,
+) error {
+	srcStat, err := os.Lstat(filepath.Join(repo.Worktree, src))
+	if err != nil {
+		return fmt.Errorf("bad source")
+	}
+
+	if srcStat.IsDir() {
+		if dst == src || strings.HasPrefix(dst, src+"/") {
+			return fmt.Errorf("can not move directory into itself")
+		}
+		hasTracked := false
+		for path := range tracked {
+			hasTracked = hasTracked || strings.HasPrefix(path, src+"/")
+		}
+		if !hasTracked {
+			return fmt.Errorf("source directory is empty")
+		}
+	} else {
+		if slices.Contains(index.Unmerged(), src) {
+			return fmt.Errorf("conflicted")
+		}
+		if _, ok := tracked[src]; !ok {
+			return fmt.Errorf("not under version control")
+		}
+	}
+
+	if dstStat, err := os.Lstat(filepath.Join(repo.Worktree, dst)); err == nil {
+		if !mvForce || srcStat.IsDir() || dstStat.IsDir() {
+			return fmt.Errorf("destination exists")
+		}
+	}
+
+	for _, m := range moves {
+		if m.dst == dst {
+			return fmt.Errorf("multiple sources for the same target")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+const diffContext = 3
+
+func blobLines(repo *repository.Repository, sha string) ([]string, error) {
+	if len(sha) == 0 {
+		return []string{}, nil
+	}
+	data, err := blobData(repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	return repository.SplitLines(data), nil
+}
+
+func shortSha(sha string) string {
+	if len(sha) == 0 {
+		return "0000000"
+	}
+	return sha[:7]
+}
+
+// filePatch renders the diff of one path between two blobs and their tree modes, an
+// empty sha meaning the path is missing on that side.
+func filePatch(repo *repository.Repository, path, oldSha, newSha string, oldMode, newMode int) (string, error) {
+	oldLines, err := blobLines(repo, oldSha)
+	if err != nil {
+		return "", err
+	}
+	newLines, err := blobLines(repo, newSha)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "diff --git a/%s b/%s\n", path, path)
+	oldName, newName := "a/"+path, "b/"+path
+	switch {
+	case len(oldSha) == 0:
+		fmt.Fprintf(&b, "new file mode %06o\n", newMode)
+		oldName = "/dev/null"
+	case len(newSha) == 0:
+		fmt.Fprintf(&b, "deleted file mode %06o\n", oldMode)
+		newName = "/dev/null"
+	case oldMode != newMode:
+		fmt.Fprintf(&b, "old mode %06o\nnew mode %06o\n", oldMode, newMode)
+	}
+	if oldSha == newSha {
+		return b.String(), nil
+	}
+	fmt.Fprintf(&b, "index %s..%s\n", shortSha(oldSha), shortSha(newSha))
+
+	hunks := repository.Hunks(repository.DiffLines(oldLines, newLines), diffContext)
+	if len(hunks) == 0 {
+		return b.String(), nil
+	}
+
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks {
+		b.WriteString(h.String())
+	}
+	return b.String(), nil
+}
+
+func changedPaths(from, to map[string]string) []string {
+	paths := make([]string, 0)
+	for path, sha := range from {
+		if to[path] != sha {
+			paths = append(paths, path)
+		}
+	}
+	for path := range to {
+		if _, ok := from[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// printTreePatch prints the patch between two flattened trees and their modes (as
+// returned by TreeToModes, missing paths are regular files).
+func printTreePatch(repo *repository.Repository, from, to map[string]string, fromModes, toModes map[string]int) error {
+	paths := changedPaths(from, to)
+	for path, sha := range from {
+		if to[path] == sha && cmp.Or(fromModes[path], 0o100644) != cmp.Or(toModes[path], 0o100644) {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		oldMode, newMode := cmp.Or(fromModes[path], 0o100644), cmp.Or(toModes[path], 0o100644)
+		patch, err := filePatch(repo, path, from[path], to[path], oldMode, newMode)
+		if err != nil {
+			return err
+		}
+		fmt.Print(patch)
+	}
+	return nil
+}
+
+func printTreeStat(repo *repository.Repository, from, to map[string]string) error {
+	paths := changedPaths(from, to)
+	width := 0
+	for _, path := range paths {
+		width = max(width, len(path))
+	}
+
+	insertions, deletions := 0, 0
+	for _, path := range paths {
+		oldLines, err := blobLines(repo, from[path])
+		if err != nil {
+			return err
+		}
+		newLines, err := blobLines(repo, to[path])
+		if err != nil {
+			return err
+		}
+
+		added, removed := 0, 0
+		for _, e := range repository.DiffLines(oldLines, newLines) {
+			switch e.Kind {
+			case repository.EditInsert:
+				added++
+			case repository.EditDelete:
+				removed++
+			}
+		}
+		insertions += added
+		deletions += removed
+
+		fmt.Printf(" %-*s | %d %s%s\n", width, path, added+removed, strings.Repeat("+", added), strings.Repeat("-", removed))
+	}
+
+	summary := fmt.Sprintf(" %d file%s changed", len(paths), plural(len(paths)))
+	if insertions > 0 {
+		summary += fmt.Sprintf(", %d insertion%s(+)", insertions, plural(insertions))
+	}
+	if deletions > 0 {
+		summary += fmt.Sprintf(", %d deletion%s(-)", deletions, plural(deletions))
+	}
+	fmt.Println(summary)
+	return nil
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	readTreeCmd.Flags().BoolVarP(&readTreeMerge, "merge", "m", false, "Perform a merge, not just a read")
+	readTreeCmd.Flags().StringVar(&readTreePrefix, "prefix", "", "Read the tree into the index under <prefix>/")
+	readTreeCmd.MarkFlagsMutuallyExclusive("merge", "prefix")
+}
+
+var (
+	readTreeMerge  bool
+	readTreePrefix string
+	readTreeCmd    = &cobra.Command{
+		Use:   "read-tree [-m | --prefix=<prefix>/] <tree-ish> [<tree-ish> [<tree-ish>]]",
+		Short: "Reads tree information into the index.",
+		Args:  cobra.RangeArgs(1, 3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			if !readTreeMerge && len(args) != 1 {
+				return fmt.Errorf("reading several trees needs -m")
+			}
+
+			trees := make([]map[string]repository.TreeLeaf, 0, len(args))
+			for _, arg := range args {
+				leaves, err := repository.TreeToLeaves(&repo, arg, "")
+				if err != nil {
+					return fmt.Errorf("failed to unpack tree object %s", arg)
+				}
+				trees = append(trees, leaves)
+			}
+
+			lock, index, err := repo.LockIndex()
+			if err != nil {
+				return err
+			}
+			defer lock.Rollback()
+
+			if !readTreeMerge && readTreePrefix == "" {
+				tree, err := repository.TreeToDict(&repo, args[0], "")
+				if err != nil {
+					return err
+				}
+				modes, err := repository.TreeToModes(&repo, args[0], "")
+				if err != nil {
+					return err
+				}
+				index, err := repo.IndexFromDict(tree, modes)
+				if err != nil {
+					return err
+				}
+				return repo.WriteIndex(lock, index)
+			}
+
+			if readTreePrefix != "" {
+				return readTreeWithPrefix(&repo, lock, index, trees[0])
+			}
+
+			if len(index.Unmerged()) != 0 {
+				return fmt.Errorf("you need to resolve your current index first")
+			}
+
+			current := make(map[string]repository.IndexEntry)
+			for _, e := range index.Entries {
+				current[e.Name] = e
+			}
+
+			var merge func(path string, e *repository.IndexEntry) ([]repository.IndexEntry, error)
+			switch len(trees) {
+			case 1:
+				merge = func(path string, e *repository.IndexEntry) ([]repository.IndexEntry, error) {
+					return leafEntries(path, 0, trees[0]), nil
+				}
+			case 2:
+				merge = func(path string, e *repository.IndexEntry) ([]repository.IndexEntry, error) {
+					return twoWayMerge(path, e, trees[0], trees[1])
+				}
+			default:
+				merge = func(path string, e *repository.IndexEntry) ([]repository.IndexEntry, error) {
+					return threeWayMerge(path, e, trees[0], trees[1], trees[2])
+				}
+			}
+
+			paths := slices.Collect(maps.Keys(current))
+			for _, tree := range trees {
+				for path := range tree {
+					if _, ok := current[path]; !ok {
+						paths = append(paths, path)
+					}
+				}
+			}
+			slices.Sort(paths)
+			paths = slices.Compact(paths)
+
+			entries := make([]repository.IndexEntry, 0, len(paths))
+			for _, path := range paths {
+				var old *repository.IndexEntry
+				if e, ok := current[path]; ok {
+					old = &e
+				}
+
+				merged, err := merge(path, old)
+				if err != nil {
+					return err
+				}
+
+				// an unchanged entry keeps its stat data
+				if len(merged) == 1 && old != nil && sameEntry(*old, merged[0]) {
+					entries = append(entries, *old)
+					continue
+				}
+				if len(merged) != 0 || old != nil {
+					index.Invalidate(path)
+				}
+				entries = append(entries, merged...)
+			}
+
+			index.Entries = entries
+			index.Sort()
+			return repo.WriteIndex(lock, index)
+		},
+	}
+)
+
+func readTreeWithPrefix(repo *repository.Repository, lock *repository.LockFile, index *repository.Index, tree map[string]repository.TreeLeaf) error {
+	prefix := strings.TrimSuffix(readTreePrefix, "/")
+	for _, e := range index.Entries {
+		if e.Name == prefix || strings.HasPrefix(e.Name, prefix+"/") {
+			return fmt.Errorf("subdirectory '%s' already exists.", prefix)
+		}
+	}
+
+	for path := range tree {
+		entries := leafEntries(path, 0, tree)
+		entries[0].Name = prefix + "/" + path
+		index.Entries = append(index.Entries, entries[0])
+	}
+	index.Invalidate(prefix + "/")
+	index.Sort()
+	return repo.WriteIndex(lock, index)
+}
+
+// leafEntries returns the index entry for path in tree at the given stage, if any.
+func leafEntries(path string, stage int, tree map[string]repository.TreeLeaf) []repository.IndexEntry {
+	leaf, ok := tree[path]
+	if !ok {
+		return nil
+	}
+	return []repository.IndexEntry{{ModeType: leaf.Mode.Type(), ModePerms: leaf.Mode.Perms(), Sha: leaf.Sha, Name: path, Stage: stage}}
+}
+
+func sameLeaf(path string, a, b map[string]repository.TreeLeaf) bool {
+	x, xOk := a[path]
+	y, yOk := b[path]
+	return xOk == yOk && x.Sha == y.Sha && x.Mode == y.Mode
+}
+
+func sameEntry(a, b repository.IndexEntry) bool {
+	return a.Sha == b.Sha && a.Mode() == b.Mode() && a.Stage == b.Stage
+}
+
+// indexMatches reports whether the index entry (nil if the path isn't staged) holds
+// the same content as path in tree.
+func indexMatches(path string, e *repository.IndexEntry, tree map[string]repository.TreeLeaf) bool {
+	leaf := leafEntries(path, 0, tree)
+	if e == nil || len(leaf) == 0 {
+		return e == nil && len(leaf) == 0
+	}
+	return sameEntry(*e, leaf[0])
+}
+
+func keepEntry(e *repository.IndexEntry) []repository.IndexEntry {
+	if e == nil {
+		return nil
+	}
+	return []repository.IndexEntry{*e}
+}
+
+// twoWayMerge moves the index from tree head to tree next, carrying over changes staged
+// on top of head as long as next does not touch the same path.
+func twoWayMerge(path string, e *repository.IndexEntry, head, next map[string]repository.TreeLeaf) ([]repository.IndexEntry, error) {
+	switch {
+	case sameLeaf(path, head, next):
+		return keepEntry(e), nil
+	case indexMatches(path, e, head):
+		return leafEntries(path, 0, next), nil
+	case indexMatches(path, e, next):
+		return keepEntry(e), nil
+	default:
+		return nil, fmt.Errorf("Entry '%s' would be overwritten by merge. Cannot merge.", path)
+	}
+}
+
+// threeWayMerge resolves path when only one side changed it from base, and otherwise
+// records base, ours and theirs in stages 1, 2 and 3. The index must not have staged
+// changes on top of ours for paths the merge touches.
+func threeWayMerge(path string, e *repository.IndexEntry, base, ours, theirs map[string]repository.TreeLeaf) ([]repository.IndexEntry, error) {
+	_, inOurs := ours[path]
+	_, inTheirs := theirs[path]
+
+	var merged []repository.IndexEntry
+	switch {
+	case sameLeaf(path, ours, theirs):
+		merged = leafEntries(path, 0, ours)
+	case sameLeaf(path, base, ours) && inTheirs:
+		merged = leafEntries(path, 0, theirs)
+	case sameLeaf(path, base, theirs) && inOurs:
+		merged = leafEntries(path, 0, ours)
+	default:
+		merged = slices.Concat(leafEntries(path, 1, base), leafEntries(path, 2, ours), leafEntries(path, 3, theirs))
+	}
+
+	if !indexMatches(path, e, ours) {
+		if len(merged) == 1 && e != nil && sameEntry(*e, merged[0]) {
+			return merged, nil
+		}
+		return nil, fmt.Errorf("Entry '%s' would be overwritten by merge. Cannot merge.", path)
+	}
+	return merged, nil
+}
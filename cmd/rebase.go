@@ -0,0 +1,1099 @@
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rebaseCmd.Flags().StringVar(&rebaseOnto, "onto", "", "Starting point at which to create the new commits")
+	rebaseCmd.Flags().BoolVarP(&rebaseInteractive, "interactive", "i", false, "Make a list of the commits which are about to be rebased and let the user edit it")
+	rebaseCmd.Flags().BoolVar(&rebaseAutosquash, "autosquash", false, "Move fixup!/squash! commits after the commits they modify")
+	rebaseCmd.Flags().BoolVar(&rebaseContinue, "continue", false, "Restart the rebasing process after resolving a conflict")
+	rebaseCmd.Flags().BoolVar(&rebaseAbort, "abort", false, "Abort the rebase and reset HEAD to the original branch")
+	rebaseCmd.Flags().BoolVar(&rebaseSkip, "skip", false, "Restart the rebasing process by skipping the current patch")
+	rebaseCmd.MarkFlagsMutuallyExclusive("continue", "abort", "skip")
+}
+
+var (
+	rebaseOnto        string
+	rebaseInteractive bool
+	rebaseAutosquash  bool
+	rebaseContinue    bool
+	rebaseAbort       bool
+	rebaseSkip        bool
+	rebaseCmd         = &cobra.Command{
+		Use:   "rebase [--onto newbase] [upstream [branch]]",
+		Short: "Reapply commits on top of another base tip.",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if rebaseContinue || rebaseAbort || rebaseSkip {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.RangeArgs(1, 2)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			state := rebaseState{repo: &repo}
+
+			switch {
+			case rebaseContinue:
+				return rebaseResume(&repo, &state)
+			case rebaseAbort:
+				return rebaseAbortRun(&repo, &state)
+			case rebaseSkip:
+				return rebaseSkipRun(&repo, &state)
+			}
+
+			if state.inProgress() {
+				return fmt.Errorf("a rebase is already in progress, try wyog rebase (--continue | --abort | --skip)")
+			}
+
+			// resolve everything before the worktree or any rebase state is touched
+			upstream, err := resolveCommit(&repo, args[0])
+			if err != nil {
+				return err
+			}
+			onto := upstream
+			if len(rebaseOnto) != 0 {
+				onto, err = resolveCommit(&repo, rebaseOnto)
+				if err != nil {
+					return err
+				}
+			}
+
+			if len(args) == 2 {
+				if err := switchBranch(&repo, args[1]); err != nil {
+					return err
+				}
+			}
+
+			return rebaseStart(&repo, &state, upstream, onto)
+		},
+	}
+)
+
+type rebaseTodo struct {
+	Command string
+	Sha     string
+	Rest    string
+}
+
+var rebaseCommands = map[string]string{
+	"p": "pick", "pick": "pick",
+	"r": "reword", "reword": "reword",
+	"e": "edit", "edit": "edit",
+	"s": "squash", "squash": "squash",
+	"f": "fixup", "fixup": "fixup",
+	"x": "exec", "exec": "exec",
+	"d": "drop", "drop": "drop",
+}
+
+func (t rebaseTodo) String() string {
+	if t.Command == "exec" {
+		return "exec " + t.Rest
+	}
+	return fmt.Sprintf("%s %s %s", t.Command, t.Sha[:7], t.Rest)
+}
+
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# e, edit <commit> = use commit, but stop for amending
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash" but keep only the previous
+#                    commit's log message
+# x, exec <command> = run command (the rest of the line) using shell
+# d, drop <commit> = remove commit
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+#
+`
+
+type rebaseState struct {
+	repo *repository.Repository
+}
+
+func (s *rebaseState) path(name string) string {
+	return s.repo.Path("rebase-merge", name)
+}
+
+func (s *rebaseState) inProgress() bool {
+	stat, err := os.Stat(s.repo.Path("rebase-merge"))
+	return err == nil && stat.IsDir()
+}
+
+func (s *rebaseState) read(name string) string {
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+func (s *rebaseState) has(name string) bool {
+	_, err := os.Stat(s.path(name))
+	return err == nil
+}
+
+func (s *rebaseState) write(name, value string) error {
+	if _, err := s.repo.DirMk("rebase-merge"); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path(name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("cannot write rebase state %s", name)
+	}
+	return nil
+}
+
+func (s *rebaseState) remove(names ...string) {
+	for _, name := range names {
+		os.Remove(s.path(name))
+	}
+}
+
+func (s *rebaseState) clear() error {
+	if err := os.RemoveAll(s.repo.Path("rebase-merge")); err != nil {
+		return fmt.Errorf("cannot remove rebase state")
+	}
+	return nil
+}
+
+func (s *rebaseState) readTodo(name string) ([]rebaseTodo, error) {
+	return parseRebaseTodo(s.repo, s.read(name))
+}
+
+func (s *rebaseState) writeTodo(name string, todo []rebaseTodo) error {
+	lines := make([]string, 0, len(todo))
+	for _, t := range todo {
+		lines = append(lines, t.String()+"\n")
+	}
+	return s.write(name, strings.Join(lines, ""))
+}
+
+func parseRebaseTodo(repo *repository.Repository, text string) ([]rebaseTodo, error) {
+	ret := make([]rebaseTodo, 0)
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		command, ok := rebaseCommands[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid command '%s' on line %d of the todo list", fields[0], i+1)
+		}
+		if len(fields) < 2 || len(strings.TrimSpace(fields[1])) == 0 {
+			return nil, fmt.Errorf("missing argument for '%s' on line %d of the todo list", command, i+1)
+		}
+
+		if command == "exec" {
+			ret = append(ret, rebaseTodo{Command: command, Rest: strings.TrimSpace(fields[1])})
+			continue
+		}
+
+		args := strings.SplitN(strings.TrimSpace(fields[1]), " ", 2)
+		sha, err := repository.ObjectFind(repo, args[0], "commit")
+		if err != nil || len(sha) == 0 {
+			return nil, fmt.Errorf("invalid commit '%s' on line %d of the todo list", args[0], i+1)
+		}
+		rest := ""
+		if len(args) == 2 {
+			rest = args[1]
+		}
+		ret = append(ret, rebaseTodo{Command: command, Sha: sha, Rest: rest})
+	}
+
+	return ret, nil
+}
+
+func reachable(repo *repository.Repository, sha string) (map[string]bool, error) {
+	seen := make(map[string]bool)
+	stack := []string{sha}
+
+	for len(stack) > 0 {
+		sha := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[sha] {
+			continue
+		}
+		seen[sha] = true
+
+		commit, err := repository.ReadCommit(repo, sha)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, commit.Parents()...)
+	}
+
+	return seen, nil
+}
+
+// rebaseCommits lists the non-merge commits reachable from head but not from upstream, oldest first.
+func rebaseCommits(repo *repository.Repository, head, upstream string) ([]string, error) {
+	exclude, err := reachable(repo, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0)
+	seen := make(map[string]bool)
+
+	var visit func(sha string) error
+	visit = func(sha string) error {
+		if seen[sha] || exclude[sha] {
+			return nil
+		}
+		seen[sha] = true
+
+		commit, err := repository.ReadCommit(repo, sha)
+		if err != nil {
+			return err
+		}
+		parents := commit.Parents()
+		for _, p := range parents {
+			if err := visit(p); err != nil {
+				return err
+			}
+		}
+		if len(parents) <= 1 {
+			ret = append(ret, sha)
+		}
+		return nil
+	}
+
+	if err := visit(head); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func autosquash(repo *repository.Repository, todo []rebaseTodo) ([]rebaseTodo, error) {
+	subjects := make(map[string]string)
+	ret := make([]rebaseTodo, 0, len(todo))
+	// the last todo index attached to each target commit
+	attached := make(map[string]int)
+
+	for _, t := range todo {
+		if t.Command != "pick" {
+			ret = append(ret, t)
+			continue
+		}
+
+		commit, err := repository.ReadCommit(repo, t.Sha)
+		if err != nil {
+			return nil, err
+		}
+		subject := commit.Subject()
+
+		command, target := "", ""
+		for prefix, c := range map[string]string{"fixup! ": "fixup", "squash! ": "squash", "amend! ": "fixup"} {
+			if strings.HasPrefix(subject, prefix) {
+				command, target = c, strings.TrimPrefix(subject, prefix)
+			}
+		}
+		for _, prefix := range []string{"fixup! ", "squash! ", "amend! "} {
+			for strings.HasPrefix(target, prefix) {
+				target = strings.TrimPrefix(target, prefix)
+			}
+		}
+
+		// like git, the first commit already in the todo list that matches is the target
+		targetSha := ""
+		if command != "" {
+			for _, e := range ret {
+				s, ok := subjects[e.Sha]
+				if ok && (s == target || (len(target) >= 4 && strings.HasPrefix(e.Sha, target))) {
+					targetSha = e.Sha
+					break
+				}
+			}
+		}
+
+		if targetSha == "" {
+			subjects[t.Sha] = subject
+			attached[t.Sha] = len(ret)
+			ret = append(ret, t)
+			continue
+		}
+
+		t.Command = command
+		at := attached[targetSha] + 1
+		ret = slices.Insert(ret, at, t)
+		for sha, i := range attached {
+			if i >= at {
+				attached[sha] = i + 1
+			}
+		}
+		attached[targetSha] = at
+	}
+
+	return ret, nil
+}
+
+func requireClean(repo *repository.Repository, action string) error {
+	index, err := repo.ReadIndex()
+	if err != nil {
+		return err
+	}
+	head, err := headDict(repo)
+	if err != nil {
+		return err
+	}
+	modes, err := headModes(repo)
+	if err != nil {
+		return err
+	}
+	if staged := repository.IndexChanges(head, modes, index); len(staged) != 0 {
+		return fmt.Errorf("cannot %s: Your index contains uncommitted changes", action)
+	}
+	unstaged, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return err
+	}
+	if len(unstaged) != 0 {
+		return fmt.Errorf("cannot %s: You have unstaged changes", action)
+	}
+	return nil
+}
+
+func headDict(repo *repository.Repository) (map[string]string, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	return commitDict(repo, head)
+}
+
+func headModes(repo *repository.Repository) (map[string]int, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	return commitModes(repo, head)
+}
+
+func commitDict(repo *repository.Repository, sha string) (map[string]string, error) {
+	if len(sha) == 0 {
+		return map[string]string{}, nil
+	}
+	return repository.TreeToDict(repo, sha, "")
+}
+
+func commitModes(repo *repository.Repository, sha string) (map[string]int, error) {
+	if len(sha) == 0 {
+		return map[string]int{}, nil
+	}
+	return repository.TreeToModes(repo, sha, "")
+}
+
+// mergeModes picks the mode of every path in a three-way merge: a mode change on
+// their side wins, otherwise ours is kept.
+func mergeModes(base, ours, theirs map[string]int) map[string]int {
+	ret := maps.Clone(ours)
+	for path, mode := range theirs {
+		if _, ok := ours[path]; !ok || mode != base[path] {
+			ret[path] = mode
+		}
+	}
+	return ret
+}
+
+// resolveCommit resolves name to a commit, failing if it names any other object.
+func resolveCommit(repo *repository.Repository, name string) (string, error) {
+	sha, err := repository.ObjectFind(repo, name, "commit")
+	if err != nil {
+		return "", err
+	}
+	if sha == "" {
+		return "", fmt.Errorf("%s is not a commit", name)
+	}
+	return sha, nil
+}
+
+func switchBranch(repo *repository.Repository, branch string) error {
+	sha, err := resolveCommit(repo, branch)
+	if err != nil {
+		return err
+	}
+	if err := requireClean(repo, "switch branches"); err != nil {
+		return err
+	}
+	if err := hardReset(repo, sha); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(repo.Path("refs", "heads", branch)); err == nil {
+		return repo.SetHead("refs/heads/" + branch)
+	}
+	return repo.SetHead(sha)
+}
+
+func rebaseStart(repo *repository.Repository, state *rebaseState, upstream, onto string) error {
+	if err := requireClean(repo, "rebase"); err != nil {
+		return err
+	}
+
+	head, err := resolveCommit(repo, "HEAD")
+	if err != nil {
+		return err
+	}
+
+	headName := "detached HEAD"
+	branch, err := repo.ActiveBranch()
+	if err != nil {
+		return err
+	}
+	if len(branch) != 0 {
+		headName = "refs/heads/" + branch
+	}
+
+	commits, err := rebaseCommits(repo, head, upstream)
+	if err != nil {
+		return err
+	}
+
+	if !rebaseInteractive && onto == upstream {
+		ancestors, err := reachable(repo, head)
+		if err != nil {
+			return err
+		}
+		if ancestors[onto] {
+			fmt.Printf("Current branch %s is up to date.\n", strings.TrimPrefix(headName, "refs/heads/"))
+			return nil
+		}
+	}
+
+	todo := make([]rebaseTodo, 0, len(commits))
+	for _, sha := range commits {
+		commit, err := repository.ReadCommit(repo, sha)
+		if err != nil {
+			return err
+		}
+		todo = append(todo, rebaseTodo{Command: "pick", Sha: sha, Rest: commit.Subject()})
+	}
+
+	if rebaseAutosquash {
+		todo, err = autosquash(repo, todo)
+		if err != nil {
+			return err
+		}
+	}
+
+	if rebaseInteractive {
+		lines := make([]string, 0, len(todo))
+		for _, t := range todo {
+			lines = append(lines, t.String())
+		}
+		text := strings.Join(lines, "\n") + "\n"
+		text += fmt.Sprintf("\n# Rebase %s..%s onto %s (%d commands)\n", upstream[:7], head[:7], onto[:7], len(todo))
+		text += rebaseTodoHelp
+
+		edited, err := editText(repo, "rebase-merge/git-rebase-todo", text)
+		if err != nil {
+			state.clear()
+			return err
+		}
+		todo, err = parseRebaseTodo(repo, edited)
+		if err != nil {
+			state.clear()
+			return err
+		}
+		if len(todo) == 0 {
+			state.clear()
+			fmt.Println("Nothing to do")
+			return nil
+		}
+		if err := state.write("interactive", ""); err != nil {
+			return err
+		}
+	}
+
+	if err := state.write("head-name", headName+"\n"); err != nil {
+		return err
+	}
+	if err := state.write("onto", onto+"\n"); err != nil {
+		return err
+	}
+	if err := state.write("orig-head", head+"\n"); err != nil {
+		return err
+	}
+	if err := state.writeTodo("git-rebase-todo", todo); err != nil {
+		return err
+	}
+	if err := state.write("done", ""); err != nil {
+		return err
+	}
+	if err := repository.RefUpdate(repo, "ORIG_HEAD", head); err != nil {
+		return err
+	}
+
+	if err := hardReset(repo, onto); err != nil {
+		return err
+	}
+	if err := repo.SetHead(onto); err != nil {
+		return err
+	}
+
+	return rebaseRun(repo, state)
+}
+
+type rebaseStop struct {
+	message string
+}
+
+func (s *rebaseStop) Error() string {
+	return s.message
+}
+
+func rebaseRun(repo *repository.Repository, state *rebaseState) error {
+	for {
+		todo, err := state.readTodo("git-rebase-todo")
+		if err != nil {
+			return err
+		}
+		if len(todo) == 0 {
+			return rebaseFinish(repo, state)
+		}
+
+		item := todo[0]
+		if err := state.writeTodo("git-rebase-todo", todo[1:]); err != nil {
+			return err
+		}
+		done, err := state.readTodo("done")
+		if err != nil {
+			return err
+		}
+		if err := state.writeTodo("done", append(done, item)); err != nil {
+			return err
+		}
+
+		if err := rebaseApply(repo, state, item, todo[1:]); err != nil {
+			if stop, ok := err.(*rebaseStop); ok && item.Command == "edit" {
+				fmt.Println(stop.message)
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func rebaseApply(repo *repository.Repository, state *rebaseState, item rebaseTodo, remaining []rebaseTodo) error {
+	switch item.Command {
+	case "drop":
+		return nil
+	case "exec":
+		fmt.Printf("Executing: %s\n", item.Rest)
+		c := exec.Command("sh", "-c", item.Rest)
+		c.Dir = repo.Worktree
+		c.Stdin, c.Stdout, c.Stderr = os.Stdin, os.Stdout, os.Stderr
+		if err := c.Run(); err != nil {
+			return &rebaseStop{fmt.Sprintf(
+				"execution failed: %s\nYou can fix the problem, and then run\n\n  wyog rebase --continue",
+				item.Rest,
+			)}
+		}
+		return nil
+	}
+
+	commit, err := repository.ReadCommit(repo, item.Sha)
+	if err != nil {
+		return err
+	}
+	parents := commit.Parents()
+	if len(parents) > 1 {
+		return fmt.Errorf("cannot rebase merge commit %s", item.Sha[:7])
+	}
+	parent := ""
+	if len(parents) == 1 {
+		parent = parents[0]
+	}
+
+	head, err := repository.ObjectFind(repo, "HEAD", "commit")
+	if err != nil {
+		return err
+	}
+
+	if parent == head && (item.Command == "pick" || item.Command == "edit") {
+		if err := hardReset(repo, item.Sha); err != nil {
+			return err
+		}
+		if err := repo.SetHead(item.Sha); err != nil {
+			return err
+		}
+		return rebaseStopForEdit(state, item, commit)
+	}
+
+	if item.Command == "squash" || item.Command == "fixup" {
+		done, err := state.readTodo("done")
+		if err != nil {
+			return err
+		}
+		previous := slices.ContainsFunc(done[:len(done)-1], func(t rebaseTodo) bool {
+			return t.Sha != "" && t.Command != "drop"
+		})
+		if !previous {
+			return fmt.Errorf("cannot '%s' without a previous commit", item.Command)
+		}
+	}
+
+	base, err := commitDict(repo, parent)
+	if err != nil {
+		return err
+	}
+	ours, err := commitDict(repo, head)
+	if err != nil {
+		return err
+	}
+	theirs, err := commitDict(repo, item.Sha)
+	if err != nil {
+		return err
+	}
+
+	modes := make([]map[string]int, 0, 3)
+	for _, sha := range []string{parent, head, item.Sha} {
+		m, err := commitModes(repo, sha)
+		if err != nil {
+			return err
+		}
+		modes = append(modes, m)
+	}
+
+	result, err := repo.MergeTrees(base, ours, theirs, repository.MergeLabels{
+		Base:   "parent of " + item.Sha[:7],
+		Ours:   "HEAD",
+		Theirs: fmt.Sprintf("%s (%s)", item.Sha[:7], commit.Subject()),
+	})
+	if err != nil {
+		return err
+	}
+
+	lock, err := repo.Lock("index")
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	index, err := applyMergeResult(repo, ours, mergeModes(modes[0], modes[1], modes[2]), result)
+	if err != nil {
+		return err
+	}
+	if err := repo.WriteIndex(lock, index); err != nil {
+		return err
+	}
+
+	if len(result.Conflicts) != 0 {
+		if err := state.write("message", commit.Message()); err != nil {
+			return err
+		}
+		author, err := commit.Author()
+		if err != nil {
+			return err
+		}
+		if err := state.write("author-script", authorScript(author)); err != nil {
+			return err
+		}
+		if err := state.write("stopped-sha", item.Sha+"\n"); err != nil {
+			return err
+		}
+		return &rebaseStop{fmt.Sprintf(
+			"could not apply %s... %s\n"+
+				"CONFLICT (content): Merge conflict in %s\n"+
+				"Resolve all conflicts manually, mark them as resolved with\n"+
+				"\"wyog add <paths>\", then run \"wyog rebase --continue\".\n"+
+				"You can instead skip this commit: run \"wyog rebase --skip\".\n"+
+				"To abort and get back to the state before \"wyog rebase\", run \"wyog rebase --abort\".",
+			item.Sha[:7], commit.Subject(), result.ConflictPaths(),
+		)}
+	}
+
+	return rebaseCommit(repo, state, item, commit, remaining)
+}
+
+func applyMergeResult(
+	repo *repository.Repository,
+	ours map[string]string,
+	modes map[string]int,
+	result *repository.MergeResult,
+) (*repository.Index, error) {
+	if err := repo.CheckoutDict(ours, result.Entries, modes); err != nil {
+		return nil, err
+	}
+
+	index, err := repo.IndexFromDict(result.Entries, modes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range result.Conflicts {
+		mode := cmp.Or(modes[c.Path], 0o100644)
+		if mode == 0o120000 {
+			mode = 0o100644
+		}
+		if err := repo.WriteWorktreeFile(c.Path, c.Content, mode); err != nil {
+			return nil, err
+		}
+		for stage, sha := range []string{c.Base, c.Ours, c.Theirs} {
+			if len(sha) == 0 {
+				continue
+			}
+			entry := repository.IndexEntry{Sha: sha, Stage: stage + 1, Name: c.Path}
+			entry.SetMode(cmp.Or(modes[c.Path], 0o100644))
+			index.Entries = append(index.Entries, entry)
+		}
+	}
+
+	index.Sort()
+	return index, nil
+}
+
+// rebaseCommit records the current index as the rewritten version of commit.
+func rebaseCommit(
+	repo *repository.Repository,
+	state *rebaseState,
+	item rebaseTodo,
+	commit *repository.Commit,
+	remaining []rebaseTodo,
+) error {
+	index, err := repo.ReadIndex()
+	if err != nil {
+		return err
+	}
+	tree, err := repo.TreeFromIndex(index)
+	if err != nil {
+		return err
+	}
+
+	head, err := repository.ObjectFind(repo, "HEAD", "commit")
+	if err != nil {
+		return err
+	}
+	headCommit, err := repository.ReadCommit(repo, head)
+	if err != nil {
+		return err
+	}
+
+	config, err := repository.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+	committer, err := committerIdent(config)
+	if err != nil {
+		return err
+	}
+
+	parents := []string{head}
+	author, err := commit.Author()
+	if err != nil {
+		return err
+	}
+	message := commit.Message()
+
+	switch item.Command {
+	case "squash", "fixup":
+		parents = headCommit.Parents()
+		author, err = headCommit.Author()
+		if err != nil {
+			return err
+		}
+		message = headCommit.Message()
+		if item.Command == "squash" {
+			message = strings.TrimRight(message, "\n") + "\n\n" + commit.Message()
+		}
+
+		chained := len(remaining) > 0 && (remaining[0].Command == "squash" || remaining[0].Command == "fixup")
+		squashed := item.Command == "squash" || state.has("squash-pending")
+		if chained && squashed {
+			if err := state.write("squash-pending", ""); err != nil {
+				return err
+			}
+		} else if squashed {
+			state.remove("squash-pending")
+			message, err = editMessage(repo, message, "# This is a combination of commits.\n")
+			if err != nil {
+				return err
+			}
+		}
+	case "reword":
+		message, err = editMessage(repo, message, "")
+		if err != nil {
+			return err
+		}
+	default:
+		empty, err := isEmptyCommit(repo, commit)
+		if err != nil {
+			return err
+		}
+		if headCommit.Tree() == tree && !empty {
+			fmt.Printf("dropping %s %s -- patch contents already upstream\n", item.Sha[:7], commit.Subject())
+			return nil
+		}
+	}
+
+	sha, err := WriteCommit(repo, tree, parents, author, committer, message)
+	if err != nil {
+		return err
+	}
+	if err := repo.SetHead(sha); err != nil {
+		return err
+	}
+
+	return rebaseStopForEdit(state, item, commit)
+}
+
+func isEmptyCommit(repo *repository.Repository, commit *repository.Commit) (bool, error) {
+	parents := commit.Parents()
+	if len(parents) == 0 {
+		return false, nil
+	}
+	parent, err := repository.ReadCommit(repo, parents[0])
+	if err != nil {
+		return false, err
+	}
+	return parent.Tree() == commit.Tree(), nil
+}
+
+func rebaseStopForEdit(state *rebaseState, item rebaseTodo, commit *repository.Commit) error {
+	if item.Command != "edit" {
+		return nil
+	}
+
+	head, err := repository.ObjectFind(state.repo, "HEAD", "commit")
+	if err != nil {
+		return err
+	}
+	if err := state.write("amend", head+"\n"); err != nil {
+		return err
+	}
+	if err := state.write("stopped-sha", item.Sha+"\n"); err != nil {
+		return err
+	}
+
+	return &rebaseStop{fmt.Sprintf(
+		"Stopped at %s...  %s\n"+
+			"You can amend the commit now by staging your changes, then run\n\n"+
+			"  wyog rebase --continue",
+		item.Sha[:7], commit.Subject(),
+	)}
+}
+
+func editMessage(repo *repository.Repository, message, header string) (string, error) {
+	text := header + message
+	text = strings.TrimRight(text, "\n") + "\n\n" +
+		"# Please enter the commit message for your changes. Lines starting\n" +
+		"# with '#' will be ignored, and an empty message aborts the commit.\n"
+
+	edited, err := editText(repo, "COMMIT_EDITMSG", text)
+	if err != nil {
+		return "", err
+	}
+	edited = stripComments(edited)
+	if len(edited) == 0 {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return edited, nil
+}
+
+func authorScript(author repository.Signature) string {
+	quote := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+
+	return fmt.Sprintf(
+		"GIT_AUTHOR_NAME=%s\nGIT_AUTHOR_EMAIL=%s\nGIT_AUTHOR_DATE=%s\n",
+		quote(author.Name), quote(author.Email), quote(fmt.Sprintf("@%d %s", author.When.Unix(), author.When.Format("-0700"))),
+	)
+}
+
+func parseAuthorScript(script string) (repository.Signature, error) {
+	values := make(map[string]string)
+	for _, line := range strings.Split(script, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.ReplaceAll(value, `'\''`, "'")
+		values[key] = strings.TrimSuffix(strings.TrimPrefix(value, "'"), "'")
+	}
+
+	when, err := parseDate(values["GIT_AUTHOR_DATE"])
+	if err != nil {
+		return repository.Signature{}, err
+	}
+	return repository.Signature{Name: values["GIT_AUTHOR_NAME"], Email: values["GIT_AUTHOR_EMAIL"], When: when}, nil
+}
+
+func rebaseResume(repo *repository.Repository, state *rebaseState) error {
+	if !state.inProgress() {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	index, err := repo.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if unmerged := index.Unmerged(); len(unmerged) != 0 {
+		return fmt.Errorf(
+			"you must edit all merge conflicts and then mark them as resolved using wyog add: %s",
+			strings.Join(unmerged, ", "),
+		)
+	}
+
+	head, err := repository.ObjectFind(repo, "HEAD", "commit")
+	if err != nil {
+		return err
+	}
+	headCommit, err := repository.ReadCommit(repo, head)
+	if err != nil {
+		return err
+	}
+	tree, err := repo.TreeFromIndex(index)
+	if err != nil {
+		return err
+	}
+
+	config, err := repository.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+	committer, err := committerIdent(config)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case state.has("amend"):
+		if tree != headCommit.Tree() {
+			author, err := headCommit.Author()
+			if err != nil {
+				return err
+			}
+			sha, err := WriteCommit(repo, tree, headCommit.Parents(), author, committer, headCommit.Message())
+			if err != nil {
+				return err
+			}
+			if err := repo.SetHead(sha); err != nil {
+				return err
+			}
+		}
+	case state.has("message"):
+		done, err := state.readTodo("done")
+		if err != nil {
+			return err
+		}
+		if tree != headCommit.Tree() && len(done) != 0 {
+			item := done[len(done)-1]
+			commit, err := repository.ReadCommit(repo, item.Sha)
+			if err != nil {
+				return err
+			}
+			author, err := parseAuthorScript(state.read("author-script"))
+			if err != nil {
+				return err
+			}
+			commit, err = repository.BuildCommit(commit.Tree(), commit.Parents(), author, committer, state.read("message"))
+			if err != nil {
+				return err
+			}
+
+			remaining, err := state.readTodo("git-rebase-todo")
+			if err != nil {
+				return err
+			}
+			if err := rebaseCommit(repo, state, item, commit, remaining); err != nil {
+				if stop, ok := err.(*rebaseStop); ok {
+					fmt.Println(stop.message)
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	state.remove("amend", "message", "author-script", "stopped-sha")
+	return rebaseRun(repo, state)
+}
+
+func rebaseSkipRun(repo *repository.Repository, state *rebaseState) error {
+	if !state.inProgress() {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	head, err := repository.ObjectFind(repo, "HEAD", "commit")
+	if err != nil {
+		return err
+	}
+	if err := hardReset(repo, head); err != nil {
+		return err
+	}
+
+	state.remove("amend", "message", "author-script", "stopped-sha")
+	return rebaseRun(repo, state)
+}
+
+func rebaseAbortRun(repo *repository.Repository, state *rebaseState) error {
+	if !state.inProgress() {
+		return fmt.Errorf("no rebase in progress")
+	}
+
+	origHead := state.read("orig-head")
+	if err := hardReset(repo, origHead); err != nil {
+		return err
+	}
+
+	headName := state.read("head-name")
+	if strings.HasPrefix(headName, "refs/") {
+		if err := repo.SetHead(headName); err != nil {
+			return err
+		}
+	} else if err := repo.SetHead(origHead); err != nil {
+		return err
+	}
+
+	return state.clear()
+}
+
+func rebaseFinish(repo *repository.Repository, state *rebaseState) error {
+	head, err := repository.ObjectFind(repo, "HEAD", "commit")
+	if err != nil {
+		return err
+	}
+
+	headName := state.read("head-name")
+	if strings.HasPrefix(headName, "refs/") {
+		if err := repository.RefUpdate(repo, headName, head); err != nil {
+			return err
+		}
+		if err := repo.SetHead(headName); err != nil {
+			return err
+		}
+	}
+
+	if err := state.clear(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Successfully rebased and updated %s.\n", headName)
+	return nil
+}
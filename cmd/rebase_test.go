@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+func TestRebaseToTreeFails(t *testing.T) {
+	repo := testRepo(t)
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repository.ReadCommit(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"rebase", "--onto", commit.Tree(), "HEAD"},
+		{"rebase", commit.Tree()},
+		{"rebase", "HEAD", commit.Tree()},
+	} {
+		err := wyog(t, args...)
+		if err == nil || !strings.Contains(err.Error(), "is not a commit") {
+			t.Errorf("wyog %s: got error %v, want not a commit", strings.Join(args, " "), err)
+		}
+		rebaseOnto = ""
+
+		if _, err := os.Stat(repo.Path("rebase-merge")); err == nil {
+			t.Errorf("wyog %s left rebase state behind", strings.Join(args, " "))
+		}
+		if _, err := os.Stat("a"); err != nil {
+			t.Errorf("wyog %s removed a tracked file: %v", strings.Join(args, " "), err)
+		}
+		if now, err := repo.Head(); err != nil || now != head {
+			t.Errorf("wyog %s moved HEAD to %q", strings.Join(args, " "), now)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	resetCmd.Flags().BoolVar(&resetSoft, "soft", false, "Only move HEAD")
+	resetCmd.Flags().BoolVar(&resetMixed, "mixed", false, "Move HEAD and reset the index (default)")
+	resetCmd.Flags().BoolVar(&resetHard, "hard", false, "Move HEAD and reset the index and working tree")
+	resetCmd.Flags().BoolVar(&resetKeep, "keep", false, "Move HEAD and reset the index, keeping local changes")
+	resetCmd.Flags().BoolVarP(&resetPatch, "patch", "p", false, "Interactively choose hunks to unstage")
+	resetCmd.MarkFlagsMutuallyExclusive("soft", "mixed", "hard", "keep", "patch")
+}
+
+var (
+	resetSoft  bool
+	resetMixed bool
+	resetHard  bool
+	resetKeep  bool
+	resetPatch bool
+	resetCmd   = &cobra.Command{
+		Use:   "reset [--soft | --mixed | --hard | --keep | -p] [commit] [-- paths...]",
+		Short: "Reset current HEAD to the specified state.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			rev, paths, err := resetArgs(&repo, args, cmd.ArgsLenAtDash())
+			if err != nil {
+				return err
+			}
+
+			if resetPatch {
+				spec, err := repo.ParsePathspec(paths)
+				if err != nil {
+					return err
+				}
+				sha, err := revisionCommit(&repo, rev)
+				if err != nil {
+					return err
+				}
+				return unstageInteractive(&repo, sha, spec)
+			}
+
+			if len(paths) != 0 {
+				if resetSoft || resetHard || resetKeep {
+					return fmt.Errorf("cannot do a --soft, --hard or --keep reset with paths")
+				}
+				spec, err := repo.ParsePathspec(paths)
+				if err != nil {
+					return err
+				}
+				return resetPaths(&repo, rev, spec)
+			}
+
+			target, err := repository.ObjectFind(&repo, rev, "commit")
+			if err != nil {
+				return err
+			}
+			if target == "" {
+				return fmt.Errorf("%s is not a commit", rev)
+			}
+
+			switch {
+			case resetSoft:
+				return resetSoftRun(&repo, target)
+			case resetHard:
+				return resetHardRun(&repo, target)
+			case resetKeep:
+				return resetKeepRun(&repo, target)
+			default:
+				return resetMixedRun(&repo, target)
+			}
+		},
+	}
+)
+
+// resetArgs splits the arguments into a revision and paths. Without "--" the first
+// argument is only taken as a revision if it resolves to one.
+func resetArgs(repo *repository.Repository, args []string, dash int) (string, []string, error) {
+	switch {
+	case dash == 0:
+		return "HEAD", args, nil
+	case dash > 1:
+		return "", nil, fmt.Errorf("only one revision allowed before '--', got '%s'", strings.Join(args[:dash], " "))
+	case dash > 0:
+		return args[0], args[dash:], nil
+	case len(args) == 0:
+		return "HEAD", nil, nil
+	}
+
+	if sha, err := repository.ObjectFind(repo, args[0], "commit"); err == nil && len(sha) != 0 {
+		return args[0], args[1:], nil
+	}
+	if _, err := os.Lstat(args[0]); err != nil {
+		return "", nil, fmt.Errorf("ambiguous argument '%s': unknown revision or path not in the working tree", args[0])
+	}
+	return "HEAD", args, nil
+}
+
+func moveHead(repo *repository.Repository, target string) error {
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if len(head) != 0 {
+		if err := repository.RefUpdate(repo, "ORIG_HEAD", head); err != nil {
+			return err
+		}
+	}
+	return repo.UpdateHead(target)
+}
+
+func resetSoftRun(repo *repository.Repository, target string) error {
+	index, err := repo.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if len(index.Unmerged()) != 0 {
+		return fmt.Errorf("cannot do a soft reset in the middle of a merge")
+	}
+	return moveHead(repo, target)
+}
+
+func resetMixedRun(repo *repository.Repository, target string) error {
+	lock, err := repo.Lock("index")
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	tree, err := commitDict(repo, target)
+	if err != nil {
+		return err
+	}
+	modes, err := commitModes(repo, target)
+	if err != nil {
+		return err
+	}
+	index, err := repo.IndexFromDict(tree, modes)
+	if err != nil {
+		return err
+	}
+	if err := repo.WriteIndex(lock, index); err != nil {
+		return err
+	}
+	if err := moveHead(repo, target); err != nil {
+		return err
+	}
+	return printUnstaged(repo, index)
+}
+
+func resetHardRun(repo *repository.Repository, target string) error {
+	if err := hardReset(repo, target); err != nil {
+		return err
+	}
+	if err := moveHead(repo, target); err != nil {
+		return err
+	}
+
+	commit, err := repository.ReadCommit(repo, target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("HEAD is now at %s %s\n", target[:7], commit.Subject())
+	return nil
+}
+
+func resetKeepRun(repo *repository.Repository, target string) error {
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	head, err := headDict(repo)
+	if err != nil {
+		return err
+	}
+	current, err := headModes(repo)
+	if err != nil {
+		return err
+	}
+	tree, err := commitDict(repo, target)
+	if err != nil {
+		return err
+	}
+	modes, err := commitModes(repo, target)
+	if err != nil {
+		return err
+	}
+
+	local, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return err
+	}
+	local = append(local, repository.IndexChanges(head, current, index)...)
+
+	from := make(map[string]string)
+	to := make(map[string]string)
+	for path := range maps.Keys(head) {
+		if head[path] != tree[path] {
+			from[path] = head[path]
+		}
+	}
+	for path := range maps.Keys(tree) {
+		if head[path] != tree[path] {
+			from[path] = head[path]
+			to[path] = tree[path]
+		}
+	}
+	for path := range from {
+		if slices.Contains(local, path) {
+			return fmt.Errorf("entry '%s' not uptodate. Cannot merge", path)
+		}
+	}
+
+	if err := repo.CheckoutDict(from, to, modes); err != nil {
+		return err
+	}
+	newIndex, err := repo.IndexFromDict(tree, modes)
+	if err != nil {
+		return err
+	}
+	if err := repo.WriteIndex(lock, newIndex); err != nil {
+		return err
+	}
+	return moveHead(repo, target)
+}
+
+// hardReset points the index and worktree at the tree of sha, discarding
+// local changes and conflicts. HEAD itself is left for the caller to move.
+func hardReset(repo *repository.Repository, sha string) error {
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+	target, err := commitDict(repo, sha)
+	if err != nil {
+		return err
+	}
+	modes, err := commitModes(repo, sha)
+	if err != nil {
+		return err
+	}
+
+	dirty, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return err
+	}
+	from := index.Dict()
+	for _, path := range dirty {
+		from[path] = ""
+	}
+	if err := repo.CheckoutDict(from, target, modes); err != nil {
+		return err
+	}
+
+	newIndex, err := repo.IndexFromDict(target, modes)
+	if err != nil {
+		return err
+	}
+	return repo.WriteIndex(lock, newIndex)
+}
+
+// revisionCommit resolves rev to a commit, allowing HEAD to name an unborn branch.
+func revisionCommit(repo *repository.Repository, rev string) (string, error) {
+	if rev == "HEAD" {
+		return repo.Head()
+	}
+	sha, err := repository.ObjectFind(repo, rev, "commit")
+	if err != nil {
+		return "", err
+	}
+	if sha == "" {
+		return "", fmt.Errorf("%s is not a commit", rev)
+	}
+	return sha, nil
+}
+
+func resetPaths(repo *repository.Repository, rev string, spec *repository.Pathspec) error {
+	sha, err := revisionCommit(repo, rev)
+	if err != nil {
+		return err
+	}
+	tree, err := commitDict(repo, sha)
+	if err != nil {
+		return err
+	}
+	modes, err := commitModes(repo, sha)
+	if err != nil {
+		return err
+	}
+
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	matched := make(map[string]string)
+	for path, sha := range tree {
+		if spec.Match(path) {
+			matched[path] = sha
+		}
+	}
+
+	entries := make([]repository.IndexEntry, 0, len(index.Entries))
+	for _, e := range index.Entries {
+		if !spec.Match(e.Name) {
+			entries = append(entries, e)
+			continue
+		}
+		index.Invalidate(e.Name)
+
+		sha, ok := matched[e.Name]
+		if !ok || e.Stage != 0 {
+			continue
+		}
+		if sha != e.Sha || modes[e.Name] != e.Mode() {
+			e = repository.IndexEntry{Sha: sha, Name: e.Name}
+			e.SetMode(modes[e.Name])
+		}
+		entries = append(entries, e)
+		delete(matched, e.Name)
+	}
+
+	for path, sha := range matched {
+		index.Invalidate(path)
+		e := repository.IndexEntry{Sha: sha, Name: path}
+		e.SetMode(modes[path])
+		entries = append(entries, e)
+	}
+
+	index.Entries = entries
+	index.Sort()
+	if err := repo.WriteIndex(lock, index); err != nil {
+		return err
+	}
+	return printUnstaged(repo, index)
+}
+
+func printUnstaged(repo *repository.Repository, index *repository.Index) error {
+	changes, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	fmt.Println("Unstaged changes after reset:")
+	for _, path := range changes {
+		fmt.Printf("M\t%s\n", path)
+	}
+	return nil
+}
+
+// unstageInteractive resets hunks of the index back to their content in the commit sha.
+func unstageInteractive(repo *repository.Repository, sha string, spec *repository.Pathspec) error {
+	tree, err := commitDict(repo, sha)
+	if err != nil {
+		return err
+	}
+	modes, err := commitModes(repo, sha)
+	if err != nil {
+		return err
+	}
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	selector := newPatchSelector(repo, patchUnstage)
+	unmerged := index.Unmerged()
+	staged := index.Dict()
+	shown := false
+
+	for _, path := range repository.IndexChanges(tree, modes, index) {
+		if !spec.Match(path) {
+			continue
+		}
+		if slices.Contains(unmerged, path) {
+			fmt.Printf("ignoring unmerged: %s\n", path)
+			continue
+		}
+		shown = true
+
+		treeSha, inTree := tree[path]
+		indexSha, inIndex := staged[path]
+		switch {
+		case !inTree:
+			ok, err := selector.selectFile(path, false)
+			if err != nil {
+				return err
+			}
+			if ok {
+				index.Entries = slices.DeleteFunc(index.Entries, func(e repository.IndexEntry) bool {
+					return e.Name == path
+				})
+				index.Invalidate(path)
+			}
+		case !inIndex:
+			ok, err := selector.selectFile(path, true)
+			if err != nil {
+				return err
+			}
+			if ok {
+				entry := repository.IndexEntry{Sha: treeSha, Name: path}
+				entry.SetMode(modes[path])
+				index.Entries = append(index.Entries, entry)
+				index.Invalidate(path)
+			}
+		default:
+			oldLines, err := blobLines(repo, treeSha)
+			if err != nil {
+				return err
+			}
+			newLines, err := blobLines(repo, indexSha)
+			if err != nil {
+				return err
+			}
+			content, changed, err := selector.selectHunks(path, oldLines, newLines)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+			blob, err := repository.Write(repository.NewBlob(content), repo)
+			if err != nil {
+				return err
+			}
+			for i, e := range index.Entries {
+				if e.Name == path {
+					entry := repository.IndexEntry{Sha: blob, Name: path}
+					entry.SetMode(e.Mode())
+					index.Entries[i] = entry
+				}
+			}
+			index.Invalidate(path)
+		}
+	}
+
+	if !shown {
+		fmt.Println("No changes.")
+		return nil
+	}
+
+	index.Sort()
+	return repo.WriteIndex(lock, index)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+// wyog runs a command in the current directory.
+func wyog(t *testing.T, args ...string) error {
+	t.Helper()
+	rootCmd.SetArgs(args)
+	return rootCmd.Execute()
+}
+
+// testRepo makes a repository with one commit of the file "a" and changes into it.
+func testRepo(t *testing.T) *repository.Repository {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	for _, role := range []string{"AUTHOR", "COMMITTER"} {
+		t.Setenv("GIT_"+role+"_NAME", "Test User")
+		t.Setenv("GIT_"+role+"_EMAIL", "test@example.com")
+	}
+
+	if err := wyog(t, "init", "."); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("a", []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wyog(t, "add", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := wyog(t, "commit", "-m", "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := repository.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &repo
+}
+
+func TestResetToTreeFails(t *testing.T) {
+	repo := testRepo(t)
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repository.ReadCommit(repo, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"reset", "--hard", commit.Tree(), "--"},
+		{"reset", "--hard", commit.Tree()},
+		{"reset", commit.Tree(), "--", "a"},
+	} {
+		err := wyog(t, args...)
+		if err == nil || !strings.Contains(err.Error(), "is not a commit") {
+			t.Errorf("wyog %s: got error %v, want not a commit", strings.Join(args, " "), err)
+		}
+		resetHard = false
+
+		if _, err := os.Stat("a"); err != nil {
+			t.Errorf("wyog %s removed a tracked file: %v", strings.Join(args, " "), err)
+		}
+		if now, err := repo.Head(); err != nil || now != head {
+			t.Errorf("wyog %s moved HEAD to %q", strings.Join(args, " "), now)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package cmd
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	restoreCmd.Flags().StringVarP(&restoreSource, "source", "s", "", "Restore the working tree files with the content from the given tree")
+	restoreCmd.Flags().BoolVarP(&restoreStaged, "staged", "S", false, "Restore the index")
+	restoreCmd.Flags().BoolVarP(&restoreWorktree, "worktree", "W", false, "Restore the working tree (default)")
+	restoreCmd.Flags().BoolVarP(&restoreForce, "force", "f", false, "Overwrite untracked files and ignore unmerged entries")
+	restoreCmd.Flags().BoolVarP(&restorePatch, "patch", "p", false, "Interactively choose hunks to restore")
+}
+
+var (
+	restoreSource   string
+	restoreStaged   bool
+	restoreWorktree bool
+	restoreForce    bool
+	restorePatch    bool
+	restoreCmd      = &cobra.Command{
+		Use:   "restore [--source=tree] [--staged] [--worktree] [-p] paths...",
+		Short: "Restore working tree files.",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if restorePatch {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			spec, err := repo.ParsePathspec(args)
+			if err != nil {
+				return err
+			}
+
+			// HEAD is left to name an unborn branch
+			source := restoreSource
+			if len(source) != 0 && source != "HEAD" {
+				tree, err := repository.ObjectFind(&repo, source, "tree")
+				if err != nil || tree == "" {
+					return fmt.Errorf("could not resolve %s", source)
+				}
+				source = tree
+			}
+
+			worktree := restoreWorktree || !restoreStaged
+			if restorePatch {
+				switch {
+				case restoreStaged && worktree:
+					return fmt.Errorf("--patch cannot restore the index and the working tree at the same time")
+				case restoreStaged:
+					if len(source) == 0 || source == "HEAD" {
+						if source, err = repo.Head(); err != nil {
+							return err
+						}
+					}
+					return unstageInteractive(&repo, source, spec)
+				default:
+					return discardInteractive(&repo, source, spec)
+				}
+			}
+
+			return restore(&repo, spec, source, restoreStaged, worktree)
+		},
+	}
+)
+
+type restoreEntry struct {
+	sha       string
+	modeType  int
+	modePerms int
+}
+
+func restore(repo *repository.Repository, spec *repository.Pathspec, source string, staged, worktree bool) error {
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return err
+	}
+	defer lock.Rollback()
+
+	if len(source) == 0 && staged {
+		source = "HEAD"
+	}
+
+	entries := make(map[string]restoreEntry)
+	if len(source) == 0 {
+		for _, e := range index.Entries {
+			if !spec.Match(e.Name) {
+				continue
+			}
+			if e.Stage != 0 {
+				if restoreForce {
+					continue
+				}
+				return fmt.Errorf("path '%s' is unmerged", e.Name)
+			}
+			entries[e.Name] = restoreEntry{e.Sha, e.ModeType, e.ModePerms}
+		}
+	} else {
+		leaves, err := sourceLeaves(repo, source)
+		if err != nil {
+			return err
+		}
+		for path, leaf := range leaves {
+			if !spec.Match(path) {
+				continue
+			}
+			entries[path] = restoreEntry{leaf.Sha, leaf.Mode.Type(), leaf.Mode.Perms()}
+		}
+	}
+
+	tracked := make(map[string]bool)
+	for _, e := range index.Entries {
+		tracked[e.Name] = true
+	}
+
+	removed := make([]string, 0)
+	if len(source) != 0 {
+		for _, e := range index.Entries {
+			if _, ok := entries[e.Name]; !ok && spec.Match(e.Name) && e.Stage == 0 {
+				removed = append(removed, e.Name)
+			}
+		}
+	}
+
+	known := slices.Clone(removed)
+	for path := range entries {
+		known = append(known, path)
+	}
+	if unmatched := spec.Unmatched(known); len(unmatched) != 0 {
+		return fmt.Errorf("pathspec '%s' did not match any file(s) known to wyog", unmatched[0])
+	}
+
+	if worktree {
+		for path := range entries {
+			if tracked[path] || restoreForce {
+				continue
+			}
+			if _, err := os.Lstat(filepath.Join(repo.Worktree, path)); err == nil {
+				return fmt.Errorf("untracked working tree file '%s' would be overwritten, use --force to overwrite it", path)
+			}
+		}
+
+		for path, e := range entries {
+			data, err := blobData(repo, e.sha)
+			if err != nil {
+				return err
+			}
+			if err := repo.WriteWorktreeFile(path, data, e.modeType<<12|e.modePerms); err != nil {
+				return err
+			}
+		}
+		for _, path := range removed {
+			if err := repo.RemoveWorktreeFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !staged {
+		return refreshIndexEntries(repo, lock, index, entries)
+	}
+
+	kept := make([]repository.IndexEntry, 0, len(index.Entries))
+	for _, e := range index.Entries {
+		if _, ok := entries[e.Name]; ok || spec.Match(e.Name) {
+			index.Invalidate(e.Name)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	for path, e := range entries {
+		index.Invalidate(path)
+		kept = append(kept, repository.IndexEntry{
+			ModeType:  e.modeType,
+			ModePerms: e.modePerms,
+			Sha:       e.sha,
+			Name:      path,
+		})
+	}
+
+	index.Entries = kept
+	return refreshIndexEntries(repo, lock, index, entries)
+}
+
+func sourceLeaves(repo *repository.Repository, source string) (map[string]repository.TreeLeaf, error) {
+	if source == "HEAD" {
+		head, err := repo.Head()
+		if err != nil {
+			return nil, err
+		}
+		if len(head) == 0 {
+			return map[string]repository.TreeLeaf{}, nil
+		}
+	}
+	return repository.TreeToLeaves(repo, source, "")
+}
+
+// refreshIndexEntries records fresh stat data for restored entries whose worktree
+// file now matches the index, so status does not need to re-hash them.
+func refreshIndexEntries(repo *repository.Repository, lock *repository.LockFile, index *repository.Index, restored map[string]restoreEntry) error {
+	for i, e := range index.Entries {
+		if _, ok := restored[e.Name]; !ok || e.Stage != 0 {
+			continue
+		}
+		sha, err := repo.HashFile(e.Name, false)
+		if err != nil || sha != e.Sha {
+			continue
+		}
+		fresh, err := repo.IndexEntryFromFile(e.Name, e.Sha)
+		if err != nil {
+			return err
+		}
+		fresh.ModeType, fresh.ModePerms = e.ModeType, e.ModePerms
+		index.Entries[i] = fresh
+	}
+
+	index.Sort()
+	return repo.WriteIndex(lock, index)
+}
+
+func blobData(repo *repository.Repository, sha string) ([]byte, error) {
+	obj, err := repository.ReadObj(repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*repository.Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a blob", sha)
+	}
+	return blob.Serialize(), nil
+}
+
+// discardInteractive reverts hunks of the worktree to their content in source, or in
+// the index when no source is given.
+func discardInteractive(repo *repository.Repository, source string, spec *repository.Pathspec) error {
+	entries := make(map[string]restoreEntry)
+	if len(source) == 0 {
+		index, err := repo.ReadIndex()
+		if err != nil {
+			return err
+		}
+		for _, e := range index.Entries {
+			if e.Stage == 0 {
+				entries[e.Name] = restoreEntry{e.Sha, e.ModeType, e.ModePerms}
+			}
+		}
+	} else {
+		leaves, err := sourceLeaves(repo, source)
+		if err != nil {
+			return err
+		}
+		for path, leaf := range leaves {
+			entries[path] = restoreEntry{leaf.Sha, leaf.Mode.Type(), leaf.Mode.Perms()}
+		}
+	}
+
+	selector := newPatchSelector(repo, patchDiscard)
+	shown := false
+
+	for _, path := range slices.Sorted(maps.Keys(entries)) {
+		e := entries[path]
+		if !spec.Match(path) {
+			continue
+		}
+
+		data, err := repo.ReadWorktreeFile(path)
+		if err != nil {
+			shown = true
+			ok, err := selector.selectFile(path, true)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			blob, err := blobData(repo, e.sha)
+			if err != nil {
+				return err
+			}
+			if err := repo.WriteWorktreeFile(path, blob, e.modeType<<12|e.modePerms); err != nil {
+				return err
+			}
+			continue
+		}
+
+		sha, err := repository.Write(repository.NewBlob(data), nil)
+		if err != nil {
+			return err
+		}
+		if sha == e.sha {
+			continue
+		}
+		shown = true
+
+		oldLines, err := blobLines(repo, e.sha)
+		if err != nil {
+			return err
+		}
+		content, changed, err := selector.selectHunks(path, oldLines, repository.SplitLines(data))
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		mode, err := repo.WorktreeMode(path)
+		if err != nil {
+			return err
+		}
+		if err := repo.WriteWorktreeFile(path, content, mode); err != nil {
+			return err
+		}
+	}
+
+	if !shown {
+		fmt.Println("No changes.")
+	}
+	return nil
+}
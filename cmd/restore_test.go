@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kbraun9118/wyog/repository"
+)
+
+func TestRestoreFromBlobFails(t *testing.T) {
+	testRepo(t)
+	blob, err := repository.Write(repository.NewBlob([]byte("a\n")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("a", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"restore", "--source", blob, "a"},
+		{"restore", "--source", blob, "--staged", "a"},
+	} {
+		err := wyog(t, args...)
+		if err == nil || !strings.Contains(err.Error(), "could not resolve "+blob) {
+			t.Errorf("wyog %s: got error %v, want could not resolve", strings.Join(args, " "), err)
+		}
+		restoreSource, restoreStaged = "", false
+
+		if data, err := os.ReadFile("a"); err != nil || string(data) != "changed\n" {
+			t.Errorf("wyog %s changed a to %q", strings.Join(args, " "), data)
+		}
+	}
+}
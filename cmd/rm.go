@@ -1,37 +1,77 @@
 package cmd
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/kbraun9118/wyog/repository"
 	"github.com/spf13/cobra"
 )
 
-var rmCmd = &cobra.Command{
-	Use:   "rm paths...",
-	Short: "Remove files from the working tree and the index.",
-	Args:  cobra.MinimumNArgs(1),
-	RunE: func(cmd *cobra.Command, args []string) error {
-		p, err := repository.FindRequire(".")
-		if err != nil {
-			return err
-		}
-		repo, err := repository.New(p)
-		if err != nil {
-			return err
-		}
-
-		index, err := repo.ReadIndex()
-		if err != nil {
-			return err
-		}
-
-		if err := repo.WriteIndex(index); err != nil {
-			return err
-		}
-
-		if err := repo.Rm(true, false, args...); err != nil {
-			return err
-		}
-
-		return nil
-	},
+func init() {
+	rmCmd.Flags().BoolVarP(&rmRecursive, "recursive", "r", false, "Allow recursive removal when a leading directory name is given")
+	rmCmd.Flags().BoolVar(&rmCached, "cached", false, "Only remove from the index, leaving the working tree alone")
+	rmCmd.Flags().BoolVar(&rmIgnoreUnmatch, "ignore-unmatch", false, "Exit with a zero status even if no files matched")
+	rmCmd.Flags().BoolVarP(&rmQuiet, "quiet", "q", false, "Do not list removed files")
 }
+
+var (
+	rmRecursive     bool
+	rmCached        bool
+	rmIgnoreUnmatch bool
+	rmQuiet         bool
+	rmCmd           = &cobra.Command{
+		Use:   "rm [-r] [--cached] pathspec...",
+		Short: "Remove files from the working tree and the index.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(p)
+			if err != nil {
+				return err
+			}
+
+			spec, err := repo.ParsePathspec(args)
+			if err != nil {
+				return err
+			}
+
+			if !rmRecursive {
+				index, err := repo.ReadIndex()
+				if err != nil {
+					return err
+				}
+				for _, arg := range args {
+					if strings.HasPrefix(arg, ":") || strings.ContainsAny(arg, "*?[") {
+						continue
+					}
+					rel, err := repo.RelPath(arg)
+					if err != nil {
+						return err
+					}
+					for _, e := range index.Entries {
+						if rel == "." || strings.HasPrefix(e.Name, rel+"/") {
+							return fmt.Errorf("not removing '%s' recursively without -r", arg)
+						}
+					}
+				}
+			}
+
+			removed, err := repo.Rm(!rmCached, rmIgnoreUnmatch, spec)
+			if err != nil {
+				return err
+			}
+
+			if !rmQuiet {
+				for _, path := range removed {
+					fmt.Printf("rm '%s'\n", path)
+				}
+			}
+
+			return nil
+		},
+	}
+)
@@ -16,6 +16,11 @@ implementation of git`,
 }
 
 func Execute() {
+	args := os.Args[1:]
+	if cmd, _, err := rootCmd.Find(args); err == nil && cmd == tagCmd {
+		args = tagArgs(args)
+	}
+	rootCmd.SetArgs(args)
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
 	}
@@ -27,16 +32,31 @@ func init() {
 		catFileCmd,
 		checkIgnoreCmd,
 		checkoutCmd,
+		cleanCmd,
 		commitCmd,
+		commitTreeCmd,
 		hashObjectCmd,
 		initCmd,
+		interpretTrailersCmd,
 		logCmd,
 		lsFilesCmd,
 		lsTreeCmd,
+		mktagCmd,
+		mktreeCmd,
+		mvCmd,
+		readTreeCmd,
+		rebaseCmd,
+		resetCmd,
+		restoreCmd,
 		revParseCmd,
 		rmCmd,
 		showRefCmd,
+		stashCmd,
 		statusCmd,
 		tagCmd,
+		updateIndexCmd,
+		verifyCommitCmd,
+		verifyTagCmd,
+		writeTreeCmd,
 	)
 }
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/kbraun9118/wyog/repository"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// defaultSigningKey is the value of the signing flags when no key is given, meaning
+// user.signingkey.
+const defaultSigningKey = "default"
+
+// signPayload signs data with the given key, or user.signingkey, in the format set by
+// gpg.format. It returns the armored signature.
+func signPayload(config *repository.Config, key string, payload []byte) ([]byte, error) {
+	if key == defaultSigningKey {
+		key = config.Get("user", "signingkey")
+	}
+
+	switch format := cmp.Or(config.Get("gpg", "format"), "openpgp"); format {
+	case "ssh":
+		if key == "" {
+			return nil, fmt.Errorf("user.signingkey needs to be set for ssh signing")
+		}
+		signer, err := sshSigner(key)
+		if err != nil {
+			return nil, err
+		}
+		return repository.SSHSign(signer, "git", payload)
+	case "openpgp":
+		keyring, err := pgpKeyring(config, true)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := repository.PGPSigningKey(keyring, key)
+		if err != nil {
+			return nil, err
+		}
+		return repository.PGPSign(signer, payload)
+	default:
+		return nil, fmt.Errorf("unsupported value for gpg.format: %s", format)
+	}
+}
+
+// pgpKeyring reads the keyring of gpg.openpgp.keyring, or for signing the one of
+// gpg.openpgp.secretKeyring if it is set.
+func pgpKeyring(config *repository.Config, secret bool) (openpgp.EntityList, error) {
+	path := config.Get("gpg.openpgp", "keyring")
+	if secret {
+		path = cmp.Or(config.Get("gpg.openpgp", "secretKeyring"), path)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("gpg.openpgp.keyring needs to be configured for openpgp signatures")
+	}
+	return repository.ReadKeyring(repository.ExpandPath(path))
+}
+
+// sshSigner loads the key named by user.signingkey: a private key file, or a public key,
+// given literally as "key::<key>" or as a file, whose private key is held by ssh-agent.
+func sshSigner(key string) (ssh.Signer, error) {
+	if literal, ok := strings.CutPrefix(key, "key::"); ok {
+		public, _, _, _, err := ssh.ParseAuthorizedKey([]byte(literal))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse ssh key %s", literal)
+		}
+		return agentSigner(public)
+	}
+
+	key = repository.ExpandPath(key)
+	data, err := os.ReadFile(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ssh signing key %s", key)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case err == nil:
+		return signer, nil
+	case errors.As(err, &missing) && missing.PublicKey != nil:
+		return agentSigner(missing.PublicKey)
+	}
+
+	public, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse ssh signing key %s", key)
+	}
+	if private, err := os.ReadFile(strings.TrimSuffix(key, ".pub")); err == nil && strings.HasSuffix(key, ".pub") {
+		if signer, err := ssh.ParsePrivateKey(private); err == nil && bytes.Equal(signer.PublicKey().Marshal(), public.Marshal()) {
+			return signer, nil
+		}
+	}
+	return agentSigner(public)
+}
+
+// agentSigner finds the private key of public in ssh-agent.
+func agentSigner(public ssh.PublicKey) (ssh.Signer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("no private key for %s and ssh-agent is not running", ssh.FingerprintSHA256(public))
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to ssh-agent")
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the keys of ssh-agent")
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), public.Marshal()) {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("ssh-agent does not hold the key %s", ssh.FingerprintSHA256(public))
+}
+
+// verifyPayload checks a signature over payload, returning a description of the signer
+// in the style of git. The signature is good when the error is nil, even if the key is
+// not among the allowed signers.
+func verifyPayload(config *repository.Config, payload, signature []byte) (string, error) {
+	switch {
+	case len(signature) == 0:
+		return "", fmt.Errorf("no signature found")
+	case bytes.HasPrefix(signature, []byte(repository.PGPSignatureBegin)):
+		return verifyPGP(config, payload, signature)
+	case bytes.HasPrefix(signature, []byte(repository.SSHSignatureBegin)):
+		return verifySSH(config, payload, signature)
+	default:
+		return "", fmt.Errorf("unsupported signature format")
+	}
+}
+
+func verifyPGP(config *repository.Config, payload, signature []byte) (string, error) {
+	keyring, err := pgpKeyring(config, false)
+	if err != nil {
+		return "", err
+	}
+	sig, signer, err := repository.PGPVerify(keyring, signature, payload)
+	if err != nil {
+		return "", err
+	}
+
+	name := ""
+	if identity := signer.PrimaryIdentity(); identity != nil {
+		name = identity.Name
+	}
+	return fmt.Sprintf("Signature made %s using %s key %s\nGood signature from \"%s\"",
+		sig.CreationTime.Format("Mon Jan 2 15:04:05 2006 -0700"),
+		pgpAlgorithm(sig.PubKeyAlgo),
+		repository.PGPFingerprint(signer.PrimaryKey),
+		name,
+	), nil
+}
+
+// pgpAlgorithm names the public key algorithm of a signature like gpg does.
+func pgpAlgorithm(algorithm packet.PublicKeyAlgorithm) string {
+	switch algorithm {
+	case packet.PubKeyAlgoRSA, packet.PubKeyAlgoRSASignOnly:
+		return "RSA"
+	case packet.PubKeyAlgoDSA:
+		return "DSA"
+	case packet.PubKeyAlgoECDSA:
+		return "ECDSA"
+	case packet.PubKeyAlgoEdDSA:
+		return "EDDSA"
+	case packet.PubKeyAlgoEd25519:
+		return "ED25519"
+	case packet.PubKeyAlgoEd448:
+		return "ED448"
+	default:
+		return fmt.Sprintf("algorithm %d", algorithm)
+	}
+}
+
+func verifySSH(config *repository.Config, payload, signature []byte) (string, error) {
+	allowedSigners := repository.ExpandPath(config.Get("gpg.ssh", "allowedSignersFile"))
+	if allowedSigners == "" {
+		return "", fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+
+	key, err := repository.SSHVerify(signature, "git", payload)
+	if err != nil {
+		return "", fmt.Errorf("Signature verification failed: %v", err)
+	}
+	principals, err := repository.AllowedSigners(allowedSigners, key, "git")
+	if err != nil {
+		return "", err
+	}
+
+	if len(principals) == 0 {
+		return fmt.Sprintf("Good \"git\" signature with %s key %s\nNo principal matched.", sshKeyType(key), ssh.FingerprintSHA256(key)), nil
+	}
+	return fmt.Sprintf("Good \"git\" signature for %s with %s key %s", strings.Join(principals, ","), sshKeyType(key), ssh.FingerprintSHA256(key)), nil
+}
+
+// sshKeyType names the kind of key like ssh-keygen does, e.g. ED25519 for ssh-ed25519.
+func sshKeyType(key ssh.PublicKey) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(key.Type(), "sk-"), "ssh-")
+	name, _, _ = strings.Cut(name, "-")
+	name, _, _ = strings.Cut(name, "@")
+	return strings.ToUpper(name)
+}
+
+// verifyObjects checks the signatures of the named commits or tags, printing the result
+// for each to stderr, and with verbose the signed payload to stdout.
+func verifyObjects(repo *repository.Repository, names []string, format string, verbose bool) error {
+	config, err := repository.ReadConfig(repo)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, name := range names {
+		// a commit may be named through a tag, but a tag must not be peeled
+		find := repository.ObjectFind
+		if format == "tag" {
+			find = repository.FindNoFollow
+		}
+		sha, err := find(repo, name, format)
+		if err != nil {
+			return err
+		}
+		if sha == "" {
+			return fmt.Errorf("%s: cannot verify a non-%s object", name, format)
+		}
+		obj, err := repository.ReadObj(repo, sha)
+		if err != nil {
+			return err
+		}
+
+		var payload, signature []byte
+		mergeTags := make([]string, 0)
+		switch obj := obj.(type) {
+		case *repository.Tag:
+			payload, signature = obj.SignaturePayload()
+		case *repository.Commit:
+			payload, signature = obj.SignaturePayload()
+			var ok bool
+			mergeTags, ok = mergeTagResults(config, obj)
+			failed = failed || !ok
+		}
+		if verbose {
+			os.Stdout.Write(payload)
+		}
+
+		for _, result := range mergeTags {
+			fmt.Fprintln(os.Stderr, result)
+		}
+		result, err := verifyPayload(config, payload, signature)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s: %v\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Fprintln(os.Stderr, result)
+	}
+	if failed {
+		return fmt.Errorf("could not verify all signatures")
+	}
+	return nil
+}
+
+// mergeTagResults checks the signatures of the tags recorded in the mergetag headers of
+// a merge commit. It reports false if any signature is bad, but not for unsigned tags.
+func mergeTagResults(config *repository.Config, commit *repository.Commit) ([]string, bool) {
+	results := make([]string, 0)
+	ok := true
+	tags, err := commit.MergeTags()
+	if err != nil {
+		return []string{err.Error()}, false
+	}
+	for _, tag := range tags {
+		payload, signature := tag.SignaturePayload()
+		result, err := verifyPayload(config, payload, signature)
+		if err != nil {
+			result = err.Error()
+			ok = ok && len(signature) == 0
+		}
+		results = append(results, fmt.Sprintf("merged tag '%s': %s", tag.Name(), result))
+	}
+	return results, ok
+}
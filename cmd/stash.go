@@ -0,0 +1,631 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, c := range []*cobra.Command{stashCmd, stashPushCmd} {
+		c.Flags().StringVarP(&stashMessage, "message", "m", "", "Description of the stash entry")
+		c.Flags().BoolVarP(&stashUntracked, "include-untracked", "u", false, "Also stash untracked files")
+	}
+	stashApplyCmd.Flags().BoolVar(&stashIndex, "index", false, "Also restore the stashed index")
+	stashPopCmd.Flags().BoolVar(&stashIndex, "index", false, "Also restore the stashed index")
+	stashShowCmd.Flags().BoolVarP(&stashPatch, "patch", "p", false, "Show the changes as a patch")
+
+	stashCmd.AddCommand(
+		stashPushCmd,
+		stashApplyCmd,
+		stashPopCmd,
+		stashListCmd,
+		stashShowCmd,
+		stashDropCmd,
+		stashClearCmd,
+		stashBranchCmd,
+	)
+}
+
+const stashRef = "refs/stash"
+
+var zeroSha = strings.Repeat("0", 40)
+
+var stashEntryRe = regexp.MustCompile(`^(?:stash@\{([0-9]+)\}|([0-9]+))$`)
+
+var (
+	stashMessage   string
+	stashUntracked bool
+	stashIndex     bool
+	stashPatch     bool
+	stashCmd       = &cobra.Command{
+		Use:   "stash",
+		Short: "Stash the changes in a dirty working directory away.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				return stashPush(repo, stashMessage, stashUntracked)
+			})
+		},
+	}
+	stashPushCmd = &cobra.Command{
+		Use:   "push [-m message] [-u]",
+		Short: "Save local modifications to a new stash entry and roll them back to HEAD.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				return stashPush(repo, stashMessage, stashUntracked)
+			})
+		},
+	}
+	stashApplyCmd = &cobra.Command{
+		Use:   "apply [--index] [stash]",
+		Short: "Apply a stash entry on top of the current working tree state.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				n, err := stashEntry(args)
+				if err != nil {
+					return err
+				}
+				conflicts, err := stashApply(repo, n, stashIndex)
+				if err != nil {
+					return err
+				}
+				if conflicts {
+					return fmt.Errorf("conflicts while applying stash@{%d}", n)
+				}
+				return nil
+			})
+		},
+	}
+	stashPopCmd = &cobra.Command{
+		Use:   "pop [--index] [stash]",
+		Short: "Apply a stash entry and remove it from the stash list.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				n, err := stashEntry(args)
+				if err != nil {
+					return err
+				}
+				conflicts, err := stashApply(repo, n, stashIndex)
+				if err != nil {
+					return err
+				}
+				if conflicts {
+					return fmt.Errorf("conflicts while applying stash@{%d}\nThe stash entry is kept in case you need it again", n)
+				}
+				return stashDrop(repo, n)
+			})
+		},
+	}
+	stashListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List the stash entries that you currently have.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				entries, err := repository.ReflogRead(repo, stashRef)
+				if err != nil {
+					return err
+				}
+				for i := range entries {
+					fmt.Printf("stash@{%d}: %s\n", i, entries[len(entries)-1-i].Message)
+				}
+				return nil
+			})
+		},
+	}
+	stashShowCmd = &cobra.Command{
+		Use:   "show [-p] [stash]",
+		Short: "Show the changes recorded in a stash entry.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				n, err := stashEntry(args)
+				if err != nil {
+					return err
+				}
+				sha, commit, err := stashCommit(repo, n)
+				if err != nil {
+					return err
+				}
+				from, err := commitDict(repo, commit.Parents()[0])
+				if err != nil {
+					return err
+				}
+				to, err := commitDict(repo, sha)
+				if err != nil {
+					return err
+				}
+				if stashPatch {
+					fromModes, err := commitModes(repo, commit.Parents()[0])
+					if err != nil {
+						return err
+					}
+					toModes, err := commitModes(repo, sha)
+					if err != nil {
+						return err
+					}
+					return printTreePatch(repo, from, to, fromModes, toModes)
+				}
+				return printTreeStat(repo, from, to)
+			})
+		},
+	}
+	stashDropCmd = &cobra.Command{
+		Use:   "drop [stash]",
+		Short: "Remove a single stash entry from the list of stash entries.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				n, err := stashEntry(args)
+				if err != nil {
+					return err
+				}
+				return stashDrop(repo, n)
+			})
+		},
+	}
+	stashClearCmd = &cobra.Command{
+		Use:   "clear",
+		Short: "Remove all the stash entries.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				if err := repository.RefDelete(repo, stashRef); err != nil {
+					return err
+				}
+				return repository.ReflogWrite(repo, stashRef, nil)
+			})
+		},
+	}
+	stashBranchCmd = &cobra.Command{
+		Use:   "branch branchname [stash]",
+		Short: "Create a branch from the commit the stash entry was based on and apply it there.",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withRepo(func(repo *repository.Repository) error {
+				n, err := stashEntry(args[1:])
+				if err != nil {
+					return err
+				}
+				return stashBranch(repo, args[0], n)
+			})
+		},
+	}
+)
+
+func withRepo(f func(repo *repository.Repository) error) error {
+	path, err := repository.FindRequire(".")
+	if err != nil {
+		return err
+	}
+	repo, err := repository.New(path)
+	if err != nil {
+		return err
+	}
+	return f(&repo)
+}
+
+func stashEntry(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	m := stashEntryRe.FindStringSubmatch(args[0])
+	if m == nil {
+		return 0, fmt.Errorf("%s is not a valid reference", args[0])
+	}
+	return strconv.Atoi(m[1] + m[2])
+}
+
+func stashCommit(repo *repository.Repository, n int) (string, *repository.Commit, error) {
+	entry, err := repository.ReflogNth(repo, stashRef, n)
+	if err != nil {
+		return "", nil, fmt.Errorf("stash@{%d} is not a valid reference", n)
+	}
+	commit, err := repository.ReadCommit(repo, entry.New)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(commit.Parents()) < 2 {
+		return "", nil, fmt.Errorf("%s is not a stash-like commit", entry.New)
+	}
+	return entry.New, commit, nil
+}
+
+func stashIdentity(repo *repository.Repository) (repository.Signature, error) {
+	config, err := repository.ReadConfig(repo)
+	if err != nil {
+		return repository.Signature{}, err
+	}
+	return committerIdent(config)
+}
+
+func stashPush(repo *repository.Repository, message string, includeUntracked bool) error {
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if len(head) == 0 {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+
+	index, err := repo.ReadIndex()
+	if err != nil {
+		return err
+	}
+	if unmerged := index.Unmerged(); len(unmerged) != 0 {
+		return fmt.Errorf("%s: needs merge", strings.Join(unmerged, ", "))
+	}
+
+	headTree, err := commitDict(repo, head)
+	if err != nil {
+		return err
+	}
+	headModes, err := commitModes(repo, head)
+	if err != nil {
+		return err
+	}
+	dirty, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return err
+	}
+	untracked := make([]string, 0)
+	if includeUntracked {
+		untracked, _, err = repo.UntrackedFiles(index)
+		if err != nil {
+			return err
+		}
+	}
+	if len(repository.IndexChanges(headTree, headModes, index)) == 0 && len(dirty) == 0 && len(untracked) == 0 {
+		fmt.Println("No local changes to save")
+		return nil
+	}
+
+	identity, err := stashIdentity(repo)
+	if err != nil {
+		return err
+	}
+	headCommit, err := repository.ReadCommit(repo, head)
+	if err != nil {
+		return err
+	}
+	branch, err := repo.ActiveBranch()
+	if err != nil {
+		return err
+	}
+	if len(branch) == 0 {
+		branch = "(no branch)"
+	}
+	desc := fmt.Sprintf("%s: %s %s", branch, head[:7], headCommit.Subject())
+
+	indexTree, err := repo.TreeFromIndex(index)
+	if err != nil {
+		return err
+	}
+	indexCommit, err := WriteCommit(repo, indexTree, []string{head}, identity, identity, "index on "+desc+"\n")
+	if err != nil {
+		return err
+	}
+
+	worktreeIndex := repository.NewIndexV2(nil)
+	for _, e := range index.Entries {
+		if slices.Contains(dirty, e.Name) {
+			if _, err := os.Lstat(filepath.Join(repo.Worktree, e.Name)); err != nil {
+				continue
+			}
+			e.Sha, err = repo.HashFile(e.Name, true)
+			if err != nil {
+				return err
+			}
+			mode, err := repo.WorktreeMode(e.Name)
+			if err != nil {
+				return err
+			}
+			e.SetMode(repo.StageMode(mode, e.Mode()))
+		}
+		worktreeIndex.Entries = append(worktreeIndex.Entries, e)
+	}
+	worktreeTree, err := repo.TreeFromIndex(&worktreeIndex)
+	if err != nil {
+		return err
+	}
+
+	parents := []string{head, indexCommit}
+	if len(untracked) != 0 {
+		untrackedIndex := repository.NewIndexV2(nil)
+		for _, path := range untracked {
+			sha, err := repo.HashFile(path, true)
+			if err != nil {
+				return err
+			}
+			mode, err := repo.WorktreeMode(path)
+			if err != nil {
+				return err
+			}
+			entry := repository.IndexEntry{Sha: sha, Name: path}
+			entry.SetMode(mode)
+			untrackedIndex.Entries = append(untrackedIndex.Entries, entry)
+		}
+		untrackedTree, err := repo.TreeFromIndex(&untrackedIndex)
+		if err != nil {
+			return err
+		}
+		untrackedCommit, err := WriteCommit(repo, untrackedTree, nil, identity, identity, "untracked files on "+desc+"\n")
+		if err != nil {
+			return err
+		}
+		parents = append(parents, untrackedCommit)
+	}
+
+	entryMessage := "WIP on " + desc
+	if len(message) != 0 {
+		entryMessage = fmt.Sprintf("On %s: %s", branch, message)
+	}
+	stash, err := WriteCommit(repo, worktreeTree, parents, identity, identity, entryMessage+"\n")
+	if err != nil {
+		return err
+	}
+
+	old, err := repository.ObjectFind(repo, stashRef, "")
+	if err != nil {
+		old = zeroSha
+	}
+	if err := repository.RefUpdate(repo, stashRef, stash); err != nil {
+		return err
+	}
+	if err := repository.ReflogAppend(repo, stashRef, repository.ReflogEntry{
+		Old:      old,
+		New:      stash,
+		Identity: identity.String(),
+		Message:  entryMessage,
+	}); err != nil {
+		return err
+	}
+
+	if err := hardReset(repo, head); err != nil {
+		return err
+	}
+	for _, path := range untracked {
+		if err := repo.RemoveWorktreeFile(path); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Saved working directory and index state %s\n", entryMessage)
+	return nil
+}
+
+func stashApply(repo *repository.Repository, n int, restoreIndex bool) (bool, error) {
+	sha, commit, err := stashCommit(repo, n)
+	if err != nil {
+		return false, err
+	}
+	parents := commit.Parents()
+
+	lock, index, err := repo.LockIndex()
+	if err != nil {
+		return false, err
+	}
+	defer lock.Rollback()
+	if len(index.Unmerged()) != 0 {
+		return false, fmt.Errorf("cannot apply a stash in the middle of a merge")
+	}
+
+	base, err := commitDict(repo, parents[0])
+	if err != nil {
+		return false, err
+	}
+	theirs, err := commitDict(repo, sha)
+	if err != nil {
+		return false, err
+	}
+	ours := index.Dict()
+
+	baseModes, err := commitModes(repo, parents[0])
+	if err != nil {
+		return false, err
+	}
+	theirsModes, err := commitModes(repo, sha)
+	if err != nil {
+		return false, err
+	}
+	modes := mergeModes(baseModes, index.Modes(), theirsModes)
+
+	dirty, err := repo.WorktreeChanges(index)
+	if err != nil {
+		return false, err
+	}
+	overwritten := make([]string, 0)
+	for _, path := range changedPaths(base, theirs) {
+		if slices.Contains(dirty, path) {
+			overwritten = append(overwritten, path)
+		}
+	}
+	if len(overwritten) != 0 {
+		return false, fmt.Errorf(
+			"your local changes to the following files would be overwritten by merge:\n\t%s\n"+
+				"Please commit your changes or stash them before you merge",
+			strings.Join(overwritten, "\n\t"),
+		)
+	}
+
+	untracked := map[string]string{}
+	untrackedModes := map[string]int{}
+	if len(parents) > 2 {
+		untracked, err = commitDict(repo, parents[2])
+		if err != nil {
+			return false, err
+		}
+		untrackedModes, err = commitModes(repo, parents[2])
+		if err != nil {
+			return false, err
+		}
+		for path := range untracked {
+			if _, err := os.Lstat(filepath.Join(repo.Worktree, path)); err == nil {
+				return false, fmt.Errorf("%s already exists, no checkout", path)
+			}
+		}
+	}
+
+	var stagedResult *repository.MergeResult
+	var stagedModes map[string]int
+	if restoreIndex {
+		stagedTree, err := commitDict(repo, parents[1])
+		if err != nil {
+			return false, err
+		}
+		indexModes, err := commitModes(repo, parents[1])
+		if err != nil {
+			return false, err
+		}
+		stagedModes = mergeModes(baseModes, index.Modes(), indexModes)
+		stagedResult, err = repo.MergeTrees(base, ours, stagedTree, repository.MergeLabels{})
+		if err != nil {
+			return false, err
+		}
+		if len(stagedResult.Conflicts) != 0 {
+			return false, fmt.Errorf("conflicts in index, try without --index")
+		}
+	}
+
+	result, err := repo.MergeTrees(base, ours, theirs, repository.MergeLabels{
+		Base:   "Stash base",
+		Ours:   "Updated upstream",
+		Theirs: "Stashed changes",
+	})
+	if err != nil {
+		return false, err
+	}
+	merged, err := applyMergeResult(repo, ours, modes, result)
+	if err != nil {
+		return false, err
+	}
+
+	for path, blob := range untracked {
+		data, err := blobData(repo, blob)
+		if err != nil {
+			return false, err
+		}
+		if err := repo.WriteWorktreeFile(path, data, untrackedModes[path]); err != nil {
+			return false, err
+		}
+	}
+
+	if len(result.Conflicts) != 0 {
+		for _, c := range result.Conflicts {
+			fmt.Printf("CONFLICT (content): Merge conflict in %s\n", c.Path)
+		}
+		return true, repo.WriteIndex(lock, merged)
+	}
+
+	var newIndex *repository.Index
+	if stagedResult != nil {
+		newIndex, err = repo.IndexFromDict(stagedResult.Entries, stagedModes)
+		if err != nil {
+			return false, err
+		}
+	} else {
+		// only additions and removals are staged, modifications are left in the worktree
+		idx := repository.NewIndexV2(nil)
+		for _, e := range index.Entries {
+			if _, ok := result.Entries[e.Name]; ok {
+				idx.Entries = append(idx.Entries, e)
+			}
+		}
+		for path, blob := range result.Entries {
+			if _, ok := ours[path]; ok {
+				continue
+			}
+			entry, err := repo.IndexEntryFromFile(path, blob)
+			if err != nil {
+				return false, err
+			}
+			idx.Entries = append(idx.Entries, entry)
+		}
+		idx.Sort()
+		newIndex = &idx
+	}
+	if err := repo.WriteIndex(lock, newIndex); err != nil {
+		return false, err
+	}
+
+	if err := StatusBranch(os.Stdout, repo); err != nil {
+		return false, err
+	}
+	if err := StatusHeadIndex(os.Stdout, repo, newIndex, nil); err != nil {
+		return false, err
+	}
+	return false, StatusIndexWorktree(os.Stdout, repo, newIndex, nil)
+}
+
+func stashDrop(repo *repository.Repository, n int) error {
+	entries, err := repository.ReflogRead(repo, stashRef)
+	if err != nil {
+		return err
+	}
+	if n >= len(entries) {
+		return fmt.Errorf("stash@{%d} is not a valid reference", n)
+	}
+
+	i := len(entries) - 1 - n
+	dropped := entries[i].New
+	entries = slices.Delete(entries, i, i+1)
+
+	if err := repository.ReflogWrite(repo, stashRef, entries); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		if err := repository.RefDelete(repo, stashRef); err != nil {
+			return err
+		}
+	} else if err := repository.RefUpdate(repo, stashRef, entries[len(entries)-1].New); err != nil {
+		return err
+	}
+
+	fmt.Printf("Dropped stash@{%d} (%s)\n", n, dropped)
+	return nil
+}
+
+func stashBranch(repo *repository.Repository, name string, n int) error {
+	_, commit, err := stashCommit(repo, n)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(repo.Path("refs", "heads", name)); err == nil {
+		return fmt.Errorf("a branch named '%s' already exists", name)
+	}
+	if err := requireClean(repo, "switch branches"); err != nil {
+		return err
+	}
+
+	base := commit.Parents()[0]
+	if err := repository.RefUpdate(repo, "refs/heads/"+name, base); err != nil {
+		return err
+	}
+	if err := hardReset(repo, base); err != nil {
+		return err
+	}
+	if err := repo.SetHead("refs/heads/" + name); err != nil {
+		return err
+	}
+	fmt.Printf("Switched to a new branch '%s'\n", name)
+
+	conflicts, err := stashApply(repo, n, true)
+	if err != nil {
+		return err
+	}
+	if conflicts {
+		return fmt.Errorf("conflicts while applying stash@{%d}", n)
+	}
+	return stashDrop(repo, n)
+}
@@ -1,19 +1,19 @@
 package cmd
 
 import (
+	"cmp"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
 	"path/filepath"
 	"slices"
-	"strings"
 
 	"github.com/kbraun9118/wyog/repository"
 	"github.com/spf13/cobra"
 )
 
 var statusCmd = &cobra.Command{
-	Use:   "status",
+	Use:   "status [pathspec...]",
 	Short: "show the working tree status.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		path, err := repository.FindRequire(".")
@@ -25,20 +25,33 @@ var statusCmd = &cobra.Command{
 			return err
 		}
 
-		index, err := repo.ReadIndex()
+		lock, index, err := repo.LockIndexIfAble()
+		if err != nil {
+			return err
+		}
+		defer lock.Rollback()
+		if _, refreshed, err := repo.RefreshIndex(index, false); err != nil {
+			return err
+		} else if refreshed && lock != nil {
+			// refreshing is only an optimisation, so a failed write is fine
+			repo.WriteIndex(lock, index)
+		}
+		lock.Rollback()
+
+		spec, err := repo.ParsePathspec(args)
 		if err != nil {
 			return err
 		}
 
-		if err := StatusBranch(&repo); err != nil {
+		if err := StatusBranch(os.Stdout, &repo); err != nil {
 			return err
 		}
 
-		if err := StatusHeadIndex(&repo, index); err != nil {
+		if err := StatusHeadIndex(os.Stdout, &repo, index, spec); err != nil {
 			return err
 		}
 
-		if err := StatusIndexWorktree(&repo, index); err != nil {
+		if err := StatusIndexWorktree(os.Stdout, &repo, index, spec); err != nil {
 			return err
 		}
 
@@ -46,34 +59,51 @@ var statusCmd = &cobra.Command{
 	},
 }
 
-func StatusBranch(repo *repository.Repository) error {
+func StatusBranch(w io.Writer, repo *repository.Repository) error {
 	branch, err := repo.ActiveBranch()
 	if err != nil {
 		return nil
 	}
 	if branch != "" {
-		fmt.Printf("On branch %s\n", branch)
+		fmt.Fprintf(w, "On branch %s\n", branch)
 	} else {
 		headObj, err := repository.ObjectFind(repo, "HEAD", "")
 		if err != nil {
 			return err
 		}
-		fmt.Printf("HEAD detatched at %s\n", headObj)
+		fmt.Fprintf(w, "HEAD detatched at %s\n", headObj)
 	}
 
 	return nil
 }
 
-func StatusHeadIndex(repo *repository.Repository, index *repository.Index) error {
+func StatusHeadIndex(w io.Writer, repo *repository.Repository, index *repository.Index, spec *repository.Pathspec) error {
 	out := make([]string, 0)
 
-	head, err := repository.TreeToDict(repo, "HEAD", "")
+	head, err := headDict(repo)
 	if err != nil {
 		return err
 	}
+	modes, err := headModes(repo)
+	if err != nil {
+		return err
+	}
+	for path := range head {
+		if !spec.Match(path) {
+			delete(head, path)
+		}
+	}
+
 	for _, entry := range index.Entries {
+		if entry.Stage != 0 {
+			delete(head, entry.Name)
+			continue
+		}
+		if !spec.Match(entry.Name) || entry.IntentToAdd {
+			continue
+		}
 		if sha, ok := head[entry.Name]; ok {
-			if sha != entry.Sha {
+			if sha != entry.Sha || cmp.Or(modes[entry.Name], 0o100644) != entry.Mode() {
 				out = append(out, fmt.Sprintf("  modified:  %s\n", entry.Name))
 			}
 			delete(head, entry.Name)
@@ -87,89 +117,72 @@ func StatusHeadIndex(repo *repository.Repository, index *repository.Index) error
 	}
 
 	if len(out) != 0 {
-		fmt.Printf("\nChanges to be committed:\n")
+		fmt.Fprintf(w, "\nChanges to be committed:\n")
 		for _, s := range out {
-			fmt.Print(s)
+			fmt.Fprint(w, s)
+		}
+	}
+
+	unmerged := slices.DeleteFunc(index.Unmerged(), func(path string) bool {
+		return !spec.Match(path)
+	})
+	if len(unmerged) != 0 {
+		fmt.Fprintf(w, "\nUnmerged paths:\n")
+		for _, path := range unmerged {
+			fmt.Fprintf(w, "  both modified:  %s\n", path)
 		}
 	}
 
 	return nil
 }
 
-func StatusIndexWorktree(repo *repository.Repository, index *repository.Index) error {
+func StatusIndexWorktree(w io.Writer, repo *repository.Repository, index *repository.Index, spec *repository.Pathspec) error {
 	notStaged := make([]string, 0)
 
-	ignore, err := repo.ReadGitignore()
-	if err != nil {
-		return err
-	}
-	gitdirPrefix := fmt.Sprintf("%s%c", repo.Gitdir, filepath.Separator)
-
-	allFiles := make([]string, 0)
-
-	filepath.WalkDir(repo.Worktree, func(path string, d fs.DirEntry, err error) error {
-		if path == repo.Gitdir || strings.HasPrefix(path, gitdirPrefix) {
-			return nil
+	for _, entry := range index.Entries {
+		if entry.Stage != 0 || entry.SkipWorktree || entry.AssumeValid || !spec.Match(entry.Name) {
+			continue
 		}
-		if !d.IsDir() {
-			relPath, err := filepath.Rel(repo.Worktree, path)
-			if err != nil {
-				return err
-			}
-			allFiles = append(allFiles, relPath)
+		if entry.IntentToAdd {
+			notStaged = append(notStaged, fmt.Sprintf("  new file:  %s\n", entry.Name))
+			continue
 		}
-		return nil
-	})
 
-	for _, entry := range index.Entries {
 		fullPath := filepath.Join(repo.Worktree, entry.Name)
 
-		if pathStat, err := os.Stat(fullPath); err == nil {
-			if !pathStat.ModTime().Equal(entry.Mtime) {
-				fd, err := os.Open(fullPath)
-				if err != nil {
-					return fmt.Errorf("cannot open file")
-				}
-				newSha, err := ObjectHash(fd, "blob", nil)
-				if err != nil {
-					return err
-				}
-				if entry.Sha != newSha {
-					notStaged = append(notStaged, fmt.Sprintf("  modified:  %s\n", entry.Name))
-				}
+		if _, err := os.Lstat(fullPath); err == nil {
+			changed, err := repo.EntryChanged(index, entry)
+			if err != nil {
+				return err
+			}
+			if changed {
+				notStaged = append(notStaged, fmt.Sprintf("  modified:  %s\n", entry.Name))
 			}
 		} else {
 			notStaged = append(notStaged, fmt.Sprintf("  deleted:   %s\n", entry.Name))
 		}
-
-		allFiles = slices.DeleteFunc(allFiles, func(name string) bool {
-			return name == entry.Name
-		})
 	}
 
 	if len(notStaged) != 0 {
-		fmt.Printf("\nChanges not staged for commit:\n")
+		fmt.Fprintf(w, "\nChanges not staged for commit:\n")
 		for _, s := range notStaged {
-			fmt.Print(s)
+			fmt.Fprint(w, s)
 		}
 	}
 
-	untrackedFiles := make([]string, 0)
-	for _, f := range allFiles {
-		ignored, err := ignore.CheckIgnore(f)
-		if err != nil {
-			return err
-		}
-		if !ignored {
-			untrackedFiles = append(untrackedFiles, f)
-		}
+	untrackedFiles, _, err := repo.UntrackedFiles(index)
+	if err != nil {
+		return err
 	}
+	untrackedFiles = slices.DeleteFunc(untrackedFiles, func(path string) bool {
+		return !spec.Match(path)
+	})
 
 	if len(untrackedFiles) != 0 {
-		fmt.Println("\nUntracked files:")
+		fmt.Fprintln(w, "\nUntracked files:")
 
 		for _, f := range untrackedFiles {
-			fmt.Printf("  %s\n", f)
+			fmt.Fprintf(w, "  %s\n", f)
 		}
 	}
 
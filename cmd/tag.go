@@ -3,22 +3,42 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path"
+	"slices"
+	"strings"
 
 	"github.com/kbraun9118/wyog/repository"
-	"github.com/kbraun9118/wyog/util"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	tagCmd.Flags().BoolVarP(&annotate, "annotate", "a", false, "Make an annotated tag")
+	tagCmd.Flags().StringArrayVarP(&tagMessages, "message", "m", nil, "Use the given tag message, implies -a")
+	tagCmd.Flags().StringVarP(&tagFile, "file", "F", "", "Take the tag message from the given file, implies -a")
+	tagCmd.Flags().BoolVarP(&tagSign, "sign", "s", false, "Make a signed tag with user.signingkey, implies -a")
+	tagCmd.Flags().StringVarP(&tagSigningKey, "local-user", "u", "", "Make a signed tag with the given key, implies -a")
+	tagCmd.Flags().BoolVarP(&tagForce, "force", "f", false, "Replace an existing tag")
+	tagCmd.Flags().BoolVarP(&tagDelete, "delete", "d", false, "Delete existing tags with the given names")
+	tagCmd.Flags().BoolVarP(&tagList, "list", "l", false, "List tags matching the given patterns")
+	tagCmd.Flags().IntVarP(&tagLines, "n", "n", 0, "Print <n> lines of each tag message when listing")
+	tagCmd.Flags().Lookup("n").NoOptDefVal = "1"
+	tagCmd.MarkFlagsMutuallyExclusive("message", "file")
+	tagCmd.MarkFlagsMutuallyExclusive("delete", "list")
 }
 
 var (
-	annotate bool
-	tagCmd   = &cobra.Command{
-		Use:   "tag [name [object]]",
+	annotate      bool
+	tagMessages   []string
+	tagFile       string
+	tagSign       bool
+	tagSigningKey string
+	tagForce      bool
+	tagDelete     bool
+	tagList       bool
+	tagLines      int
+	tagCmd        = &cobra.Command{
+		Use:   "tag [-a | -s | -u <key-id>] [-f] [-m <msg> | -F <file>] <name> [<object>] | -d <name>... | [-l] [-n[=<num>]] [<pattern>...]",
 		Short: "List and create tags",
-		Args:  cobra.MaximumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			path, err := repository.FindRequire(".")
 			if err != nil {
@@ -29,75 +49,263 @@ var (
 				return err
 			}
 
-			if len(args) > 0 {
-				obj := "HEAD"
-				if len(args) == 2 {
-					obj = args[1]
-				}
-
-				tagCreate(&repo, args[0], obj, annotate)
-			} else {
-				refs, err := repository.RefList(&repo, nil)
-				if err != nil {
-					return err
+			switch {
+			case tagDelete:
+				if len(args) == 0 {
+					return fmt.Errorf("tag -d needs at least one tag name")
 				}
+				return tagDeleteAll(&repo, args)
+			case tagList || len(args) == 0 || cmd.Flags().Changed("n"):
+				return tagListAll(&repo, args)
+			}
 
-				tagRefs, ok := refs["tags"].(map[string]any)
-				if !ok {
-					return fmt.Errorf("tags ref is incorrect")
-				}
-				err = showRef(&repo, tagRefs, false, "")
-				if err != nil {
-					return err
-				}
+			if len(args) > 2 {
+				return fmt.Errorf("too many arguments")
+			}
+			obj := "HEAD"
+			if len(args) == 2 {
+				obj = args[1]
 			}
 
-			return nil
+			signingKey := tagSigningKey
+			if tagSign && signingKey == "" {
+				signingKey = defaultSigningKey
+			}
+			annotated := annotate || signingKey != "" || len(tagMessages) != 0 || tagFile != ""
+			return tagCreate(&repo, args[0], obj, annotated, signingKey)
 		},
 	}
 )
 
-func tagCreate(repo *repository.Repository, name string, ref string, annotate bool) error {
+func tagCreate(repo *repository.Repository, name string, ref string, annotate bool, signingKey string) error {
+	if !validRefName("tags/" + name) {
+		return fmt.Errorf("'%s' is not a valid tag name.", name)
+	}
+
 	sha, err := repository.ObjectFind(repo, ref, "")
 	if err != nil {
 		return err
 	}
 
+	old, exists := tagSha(repo, name)
+	if exists && !tagForce {
+		return fmt.Errorf("tag '%s' already exists", name)
+	}
+
 	if annotate {
-		kvlm := util.NewLinkedMap[string, []string]()
-		kvlm.Set("object", []string{sha})
-		kvlm.Set("type", []string{"commit"})
-		kvlm.Set("tag", []string{name})
-		kvlm.Set("tagger", []string{"kbraun <kbraun@kbraun.dev>"})
-		tag := repository.Tag{
-			Commit: &repository.Commit{
-				Kvlm: repository.KvlmData{
-					LinkedMap: kvlm,
-					Message:   []byte("A tag generated by wyag, which won't let you customize the message!\n"),
-				},
-			},
-		}
-		var err error
-		sha, err = repository.Write(&tag, repo)
+		message, err := tagMessage(repo, name)
+		if err != nil {
+			return err
+		}
+		sha, err = writeTag(repo, name, sha, message, signingKey)
 		if err != nil {
 			return err
 		}
 	}
-	err = refCreate(repo, "tags/"+name, sha)
-	if err != nil {
+
+	if err := repository.RefUpdate(repo, "refs/tags/"+name, sha); err != nil {
 		return err
 	}
+	if exists && old != sha {
+		fmt.Printf("Updated tag '%s' (was %s)\n", name, old[:7])
+	}
 	return nil
 }
 
-func refCreate(repo *repository.Repository, refName, sha string) error {
-	fileName, err := repo.File("refs", refName)
+// writeTag writes an annotated tag, signed with signingKey unless it is empty.
+func writeTag(repo *repository.Repository, name, sha, message, signingKey string) (string, error) {
+	obj, err := repository.ReadObj(repo, sha)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	config, err := repository.ReadConfig(repo)
+	if err != nil {
+		return "", err
 	}
-	err = os.WriteFile(*fileName, []byte(sha+"\n"), 0644)
+	tagger, err := committerIdent(config)
 	if err != nil {
-		return fmt.Errorf("could not save file")
+		return "", err
+	}
+
+	tag, err := repository.BuildTag(sha, string(obj.Fmt()), name, tagger, message)
+	if err != nil {
+		return "", err
+	}
+	if signingKey != "" {
+		sig, err := signPayload(config, signingKey, tag.Serialize())
+		if err != nil {
+			return "", err
+		}
+		tag.SetSignature(sig)
+	}
+	return repository.Write(tag, repo)
+}
+
+const tagTemplate = `
+#
+# Write a message for tag:
+#   %s
+# Lines starting with '#' will be ignored.
+`
+
+// tagMessage takes the message from -m or -F, or asks for it in the editor.
+func tagMessage(repo *repository.Repository, name string) (string, error) {
+	var message string
+	switch {
+	case len(tagMessages) != 0:
+		message = strings.Join(tagMessages, "\n\n")
+	case tagFile != "":
+		data, err := readMessageFile(tagFile)
+		if err != nil {
+			return "", err
+		}
+		message = string(data)
+	default:
+		edited, err := editText(repo, "TAG_EDITMSG", fmt.Sprintf(tagTemplate, name))
+		if err != nil {
+			return "", err
+		}
+		message = stripComments(edited)
+		if message == "" {
+			return "", fmt.Errorf("no tag message?")
+		}
+		return message, nil
+	}
+
+	message = strings.TrimRight(message, "\n")
+	if message == "" {
+		return "", nil
+	}
+	return message + "\n", nil
+}
+
+func tagDeleteAll(repo *repository.Repository, names []string) error {
+	failed := false
+	for _, name := range names {
+		sha, ok := tagSha(repo, name)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "error: tag '%s' not found.\n", name)
+			failed = true
+			continue
+		}
+		if err := repository.RefDelete(repo, "refs/tags/"+name); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted tag '%s' (was %s)\n", name, sha[:7])
+	}
+	if failed {
+		return fmt.Errorf("some tags could not be deleted")
+	}
+	return nil
+}
+
+func tagListAll(repo *repository.Repository, patterns []string) error {
+	tagsDir, err := repo.Dir("refs", "tags")
+	if err != nil || tagsDir == nil {
+		return nil
+	}
+	refs, err := repository.RefList(repo, tagsDir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0)
+	var walk func(refs map[string]any, prefix string)
+	walk = func(refs map[string]any, prefix string) {
+		for k, v := range refs {
+			if sub, ok := v.(map[string]any); ok {
+				walk(sub, prefix+k+"/")
+			} else {
+				names = append(names, prefix+k)
+			}
+		}
+	}
+	walk(refs, "")
+	slices.Sort(names)
+
+	for _, name := range names {
+		if len(patterns) != 0 && !slices.ContainsFunc(patterns, func(pattern string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}) {
+			continue
+		}
+
+		if tagLines == 0 {
+			fmt.Println(name)
+			continue
+		}
+		annotation, err := tagAnnotation(repo, name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%-15s %s\n", name, strings.Join(annotation, "\n    "))
 	}
 	return nil
 }
+
+// tagArgs rewrites "-n<num>" to "-n=<num>". As the value of -n is optional, pflag
+// would otherwise read the digits as more shorthand flags.
+func tagArgs(args []string) []string {
+	ret := slices.Clone(args)
+	for i, arg := range ret {
+		if arg == "--" {
+			break
+		}
+		num, ok := strings.CutPrefix(arg, "-n")
+		if ok && len(num) != 0 && strings.Trim(num, "0123456789") == "" {
+			ret[i] = "-n=" + num
+		}
+	}
+	return ret
+}
+
+// tagAnnotation returns the first lines of the tag message, or of the commit message
+// for a lightweight tag.
+func tagAnnotation(repo *repository.Repository, name string) ([]string, error) {
+	sha, _ := tagSha(repo, name)
+	obj, err := repository.ReadObj(repo, sha)
+	if err != nil {
+		return nil, err
+	}
+	var message string
+	switch obj := obj.(type) {
+	case *repository.Tag:
+		payload, _ := obj.SplitSignature()
+		message = string(payload)
+	case *repository.Commit:
+		message = obj.Message()
+	default:
+		return nil, nil
+	}
+
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	return lines[:min(len(lines), tagLines)], nil
+}
+
+// validRefName checks the rules of git check-ref-format that a user is likely to trip over.
+func validRefName(name string) bool {
+	if strings.ContainsAny(name, " ~^:?*[\\\x7f") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, c := range name {
+		if c < 0x20 {
+			return false
+		}
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.HasPrefix(part, ".") || strings.HasSuffix(part, ".lock") {
+			return false
+		}
+	}
+	return !strings.HasSuffix(name, ".") && !strings.HasPrefix(name, "tags/-")
+}
+
+func tagSha(repo *repository.Repository, name string) (string, bool) {
+	sha, err := repository.RefResolve(repo, repo.Path("refs", "tags", name))
+	if err != nil || sha == nil {
+		return "", false
+	}
+	return *sha, true
+}
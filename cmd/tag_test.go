@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTagArgs(t *testing.T) {
+	for _, tt := range []struct{ args, want []string }{
+		{[]string{"tag", "-n"}, []string{"tag", "-n"}},
+		{[]string{"tag", "-n12", "v*"}, []string{"tag", "-n=12", "v*"}},
+		{[]string{"tag", "-n=3"}, []string{"tag", "-n=3"}},
+		{[]string{"tag", "-nx"}, []string{"tag", "-nx"}},
+		{[]string{"tag", "-l", "--", "-n2"}, []string{"tag", "-l", "--", "-n2"}},
+	} {
+		if got := tagArgs(tt.args); !slices.Equal(got, tt.want) {
+			t.Errorf("tagArgs(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
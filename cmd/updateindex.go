@@ -0,0 +1,295 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	updateIndexCmd.Flags().BoolVar(&updateIndexAdd, "add", false, "Add files that are not in the index yet")
+	updateIndexCmd.Flags().BoolVar(&updateIndexRemove, "remove", false, "Remove files that are in the index but missing from the working tree")
+	updateIndexCmd.Flags().BoolVar(&updateIndexForceRemove, "force-remove", false, "Remove files from the index even if they still exist in the working tree")
+	updateIndexCmd.Flags().StringArrayVar(&updateIndexCacheInfo, "cacheinfo", nil, "Insert <mode>,<object>,<path> directly into the index")
+	updateIndexCmd.Flags().BoolVar(&updateIndexIndexInfo, "index-info", false, "Read index information from stdin")
+	updateIndexCmd.Flags().StringVar(&updateIndexChmod, "chmod", "", "Set (+x) or clear (-x) the executable bit of the files")
+	updateIndexCmd.Flags().BoolVar(&updateIndexAssumeUnchanged, "assume-unchanged", false, "Mark the files as unchanged, so their worktree copies are not checked")
+	updateIndexCmd.Flags().BoolVar(&updateIndexNoAssumeUnchanged, "no-assume-unchanged", false, "Clear the assume-unchanged bit")
+	updateIndexCmd.Flags().BoolVar(&updateIndexSkipWorktree, "skip-worktree", false, "Mark the files to be skipped in the working tree")
+	updateIndexCmd.Flags().BoolVar(&updateIndexNoSkipWorktree, "no-skip-worktree", false, "Clear the skip-worktree bit")
+	updateIndexCmd.Flags().BoolVar(&updateIndexRefresh, "refresh", false, "Refresh the stat information of entries whose files are unchanged")
+	updateIndexCmd.Flags().BoolVar(&updateIndexReallyRefresh, "really-refresh", false, "Like --refresh, but also check assume-unchanged files")
+	updateIndexCmd.Flags().BoolVarP(&updateIndexQuiet, "quiet", "q", false, "Continue refreshing even when files need updating")
+	updateIndexCmd.Flags().IntVar(&updateIndexVersion, "index-version", 0, "Write the index in the given on-disk format version (2, 3 or 4)")
+	updateIndexCmd.MarkFlagsMutuallyExclusive("assume-unchanged", "no-assume-unchanged")
+	updateIndexCmd.MarkFlagsMutuallyExclusive("skip-worktree", "no-skip-worktree")
+}
+
+var (
+	updateIndexAdd               bool
+	updateIndexRemove            bool
+	updateIndexForceRemove       bool
+	updateIndexCacheInfo         []string
+	updateIndexIndexInfo         bool
+	updateIndexChmod             string
+	updateIndexAssumeUnchanged   bool
+	updateIndexNoAssumeUnchanged bool
+	updateIndexSkipWorktree      bool
+	updateIndexNoSkipWorktree    bool
+	updateIndexRefresh           bool
+	updateIndexReallyRefresh     bool
+	updateIndexQuiet             bool
+	updateIndexVersion           int
+	updateIndexCmd               = &cobra.Command{
+		Use:   "update-index [--add] [--remove | --force-remove] [--cacheinfo <mode>,<object>,<path>] [--index-info] [--chmod=(+|-)x] [--[no-]assume-unchanged] [--[no-]skip-worktree] [--refresh | --really-refresh] [-q] [--index-version n] [file...]",
+		Short: "Register file contents in the working tree to the index.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			if updateIndexChmod != "" && updateIndexChmod != "+x" && updateIndexChmod != "-x" {
+				return fmt.Errorf("option 'chmod' expects \"+x\" or \"-x\"")
+			}
+
+			lock, index, err := repo.LockIndex()
+			if err != nil {
+				return err
+			}
+			defer lock.Rollback()
+
+			for _, info := range updateIndexCacheInfo {
+				parts := strings.SplitN(info, ",", 3)
+				if len(parts) != 3 {
+					return fmt.Errorf("option 'cacheinfo' expects <mode>,<sha1>,<path>")
+				}
+				entry, err := cacheInfoEntry(parts[0], parts[1], parts[2])
+				if err != nil {
+					return err
+				}
+				if !updateIndexAdd && !slices.ContainsFunc(index.Entries, func(e repository.IndexEntry) bool {
+					return e.Name == entry.Name
+				}) {
+					return fmt.Errorf("%s: cannot add to the index - missing --add option?", entry.Name)
+				}
+				setIndexEntry(index, entry)
+			}
+
+			if updateIndexIndexInfo {
+				if err := readIndexInfo(index); err != nil {
+					return err
+				}
+			}
+
+			for _, arg := range args {
+				rel, err := repo.RelPath(arg)
+				if err != nil {
+					return err
+				}
+				if err := updateIndexPath(&repo, index, rel); err != nil {
+					return err
+				}
+			}
+
+			if cmd.Flags().Changed("index-version") {
+				if updateIndexVersion < 2 || updateIndexVersion > 4 {
+					return fmt.Errorf("index-version %d not in range: 2..4", updateIndexVersion)
+				}
+				index.Version = updateIndexVersion
+			}
+
+			stale := make([]string, 0)
+			if updateIndexRefresh || updateIndexReallyRefresh {
+				stale, _, err = repo.RefreshIndex(index, updateIndexReallyRefresh)
+				if err != nil {
+					return err
+				}
+			}
+
+			if err := repo.WriteIndex(lock, index); err != nil {
+				return err
+			}
+
+			if len(stale) != 0 && !updateIndexQuiet {
+				for i, path := range stale {
+					stale[i] = fmt.Sprintf("%s: needs update", path)
+				}
+				return fmt.Errorf("%s", strings.Join(stale, "\n"))
+			}
+			return nil
+		},
+	}
+)
+
+// updateIndexPath applies the path options to a single file: the flag-only options only
+// touch its entry, otherwise the entry is updated from the working tree first.
+func updateIndexPath(repo *repository.Repository, index *repository.Index, path string) error {
+	flagsOnly := updateIndexAssumeUnchanged || updateIndexNoAssumeUnchanged ||
+		updateIndexSkipWorktree || updateIndexNoSkipWorktree
+
+	if !flagsOnly {
+		if updateIndexForceRemove {
+			removeIndexEntry(index, path)
+			return nil
+		}
+
+		stat, err := os.Lstat(filepath.Join(repo.Worktree, path))
+		switch {
+		case err != nil:
+			if !updateIndexRemove {
+				return fmt.Errorf("%s: does not exist and --remove not passed", path)
+			}
+			removeIndexEntry(index, path)
+			return nil
+		case stat.IsDir():
+			return fmt.Errorf("%s: is a directory - add files inside instead", path)
+		}
+
+		i := slices.IndexFunc(index.Entries, func(e repository.IndexEntry) bool { return e.Name == path })
+		if i < 0 && !updateIndexAdd {
+			return fmt.Errorf("%s: cannot add to the index - missing --add option?", path)
+		}
+
+		sha, err := repo.HashFile(path, true)
+		if err != nil {
+			return err
+		}
+		entry, err := repo.IndexEntryFromFile(path, sha)
+		if err != nil {
+			return err
+		}
+		if i >= 0 {
+			old := index.Entries[i]
+			entry.SetMode(repo.StageMode(entry.Mode(), old.Mode()))
+			entry.AssumeValid, entry.SkipWorktree = old.AssumeValid, old.SkipWorktree
+		}
+		setIndexEntry(index, entry)
+	}
+
+	found := false
+	for i, e := range index.Entries {
+		if e.Name != path || e.Stage != 0 {
+			continue
+		}
+		found = true
+		entry := &index.Entries[i]
+
+		if updateIndexChmod != "" {
+			if entry.ModeType != 0b1000 {
+				return fmt.Errorf("cannot chmod %sx '%s'", updateIndexChmod[:1], path)
+			}
+			mode := 0o100644
+			if updateIndexChmod == "+x" {
+				mode = 0o100755
+			}
+			if entry.Mode() != mode {
+				entry.SetMode(mode)
+				index.Invalidate(path)
+			}
+		}
+		if updateIndexAssumeUnchanged || updateIndexNoAssumeUnchanged {
+			entry.AssumeValid = updateIndexAssumeUnchanged
+		}
+		if updateIndexSkipWorktree || updateIndexNoSkipWorktree {
+			entry.SkipWorktree = updateIndexSkipWorktree
+		}
+	}
+	if !found && flagsOnly {
+		return fmt.Errorf("Unable to mark file %s", path)
+	}
+
+	return nil
+}
+
+// readIndexInfo applies lines from stdin in any of the formats
+//
+//	<mode> SP <sha1> TAB <path>
+//	<mode> SP <type> SP <sha1> TAB <path>
+//	<mode> SP <sha1> SP <stage> TAB <path>
+//
+// where a mode of 0 removes the path.
+func readIndexInfo(index *repository.Index) error {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Text()
+		meta, path, ok := strings.Cut(line, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("malformed index info %s", line)
+		}
+		if unquoted, err := strconv.Unquote(path); err == nil {
+			path = unquoted
+		}
+
+		stage := 0
+		sha := fields[1]
+		if len(fields) == 3 {
+			if s, err := strconv.Atoi(fields[2]); err == nil && len(fields[2]) == 1 {
+				stage = s
+			} else {
+				sha = fields[2]
+			}
+		}
+
+		if fields[0] == "0" {
+			removeIndexEntry(index, path)
+			continue
+		}
+
+		entry, err := cacheInfoEntry(fields[0], sha, path)
+		if err != nil {
+			return fmt.Errorf("malformed index info %s", line)
+		}
+		if stage < 0 || stage > 3 {
+			return fmt.Errorf("malformed index info %s", line)
+		}
+		entry.Stage = stage
+		setIndexEntry(index, entry)
+	}
+	return scanner.Err()
+}
+
+func cacheInfoEntry(mode, sha, path string) (repository.IndexEntry, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || !slices.Contains([]uint64{0o100644, 0o100755, 0o120000, 0o160000}, value) {
+		return repository.IndexEntry{}, fmt.Errorf("invalid mode %s for '%s'", mode, path)
+	}
+	name := strings.ToLower(sha)
+	if !repository.ValidObjectName(name) {
+		return repository.IndexEntry{}, fmt.Errorf("invalid object name %s for '%s'", sha, path)
+	}
+
+	entry := repository.IndexEntry{Sha: name, Name: path}
+	entry.SetMode(int(value))
+	return entry, nil
+}
+
+// setIndexEntry replaces the entry for the same path and stage. A stage 0 entry resolves
+// any conflict, and a conflict stage replaces the resolved entry.
+func setIndexEntry(index *repository.Index, entry repository.IndexEntry) {
+	index.Entries = slices.DeleteFunc(index.Entries, func(e repository.IndexEntry) bool {
+		return e.Name == entry.Name && (entry.Stage == 0 || e.Stage == 0 || e.Stage == entry.Stage)
+	})
+	index.Entries = append(index.Entries, entry)
+	index.Invalidate(entry.Name)
+	index.Sort()
+}
+
+func removeIndexEntry(index *repository.Index, path string) {
+	index.Entries = slices.DeleteFunc(index.Entries, func(e repository.IndexEntry) bool {
+		return e.Name == path
+	})
+	index.Invalidate(path)
+}
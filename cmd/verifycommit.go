@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyCommitCmd.Flags().BoolVarP(&verifyCommitVerbose, "verbose", "v", false, "Print the contents of the commit object before validating it")
+}
+
+var (
+	verifyCommitVerbose bool
+	verifyCommitCmd     = &cobra.Command{
+		Use:   "verify-commit [-v] <commit>...",
+		Short: "Check the signature of commits.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			return verifyObjects(&repo, args, "commit", verifyCommitVerbose)
+		},
+	}
+)
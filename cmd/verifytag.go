@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyTagCmd.Flags().BoolVarP(&verifyTagVerbose, "verbose", "v", false, "Print the contents of the tag object before validating it")
+}
+
+var (
+	verifyTagVerbose bool
+	verifyTagCmd     = &cobra.Command{
+		Use:   "verify-tag [-v] <tag>...",
+		Short: "Check the signature of tags.",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			return verifyObjects(&repo, args, "tag", verifyTagVerbose)
+		},
+	}
+)
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kbraun9118/wyog/repository"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	writeTreeCmd.Flags().StringVar(&writeTreePrefix, "prefix", "", "Write the tree object for the subdirectory <prefix>")
+}
+
+var (
+	writeTreePrefix string
+	writeTreeCmd    = &cobra.Command{
+		Use:   "write-tree [--prefix=<prefix>/]",
+		Short: "Create a tree object from the current index.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("prefix") && strings.Trim(writeTreePrefix, "/") == "" {
+				return fmt.Errorf("invalid prefix '%s'", writeTreePrefix)
+			}
+
+			path, err := repository.FindRequire(".")
+			if err != nil {
+				return err
+			}
+			repo, err := repository.New(path)
+			if err != nil {
+				return err
+			}
+
+			lock, index, err := repo.LockIndexIfAble()
+			if err != nil {
+				return err
+			}
+			defer lock.Rollback()
+			sha, err := repo.TreeFromIndex(index)
+			if err != nil {
+				return err
+			}
+
+			if writeTreePrefix != "" {
+				subtree := index.Tree.Find(writeTreePrefix)
+				if subtree == nil {
+					return fmt.Errorf("prefix %s not found", writeTreePrefix)
+				}
+				sha = subtree.Sha
+			}
+
+			// caching the written trees is only an optimisation, like refreshing in status
+			if lock != nil {
+				repo.WriteIndex(lock, index)
+			}
+
+			fmt.Println(sha)
+			return nil
+		},
+	}
+)
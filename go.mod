@@ -3,9 +3,16 @@ module github.com/kbraun9118/wyog
 go 1.24.5
 
 require (
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06
+	github.com/spf13/cobra v1.9.1
+	golang.org/x/crypto v0.48.0
+	gopkg.in/ini.v1 v1.67.0
+)
+
+require (
+	github.com/cloudflare/circl v1.6.1 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
 	github.com/spf13/pflag v1.0.7 // indirect
-	gopkg.in/ini.v1 v1.67.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
 )
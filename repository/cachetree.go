@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CacheTree is the index's `TREE` extension: the tree object already written for each
+// directory, so that unchanged directories don't need to be hashed again. A node with a
+// negative Entries count has been invalidated.
+type CacheTree struct {
+	Name     string
+	Entries  int
+	Sha      string
+	Subtrees []*CacheTree
+}
+
+func parseCacheTree(data []byte) (*CacheTree, error) {
+	tree, rest, err := parseCacheTreeNode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("corrupt cache tree extension")
+	}
+	return tree, nil
+}
+
+func parseCacheTreeNode(data []byte) (*CacheTree, []byte, error) {
+	corrupt := fmt.Errorf("corrupt cache tree extension")
+
+	nullIdx := bytes.IndexByte(data, 0x00)
+	newlineIdx := bytes.IndexByte(data, '\n')
+	if nullIdx < 0 || newlineIdx < nullIdx {
+		return nil, nil, corrupt
+	}
+	counts := strings.Fields(string(data[nullIdx+1 : newlineIdx]))
+	if len(counts) != 2 {
+		return nil, nil, corrupt
+	}
+	entries, err := strconv.Atoi(counts[0])
+	if err != nil {
+		return nil, nil, corrupt
+	}
+	subtrees, err := strconv.Atoi(counts[1])
+	if err != nil || subtrees < 0 {
+		return nil, nil, corrupt
+	}
+
+	tree := &CacheTree{Name: string(data[:nullIdx]), Entries: entries}
+	rest := data[newlineIdx+1:]
+	if entries >= 0 {
+		if len(rest) < 20 {
+			return nil, nil, corrupt
+		}
+		tree.Sha = hex.EncodeToString(rest[:20])
+		rest = rest[20:]
+	}
+
+	for range subtrees {
+		var sub *CacheTree
+		sub, rest, err = parseCacheTreeNode(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		tree.Subtrees = append(tree.Subtrees, sub)
+	}
+
+	return tree, rest, nil
+}
+
+func (t *CacheTree) encode(buf []byte) []byte {
+	buf = append(buf, t.Name...)
+	buf = append(buf, 0x00)
+	buf = fmt.Appendf(buf, "%d %d\n", t.Entries, len(t.Subtrees))
+	if t.Entries >= 0 {
+		sha, _ := hex.DecodeString(t.Sha)
+		buf = append(buf, sha...)
+	}
+	for _, sub := range t.Subtrees {
+		buf = sub.encode(buf)
+	}
+	return buf
+}
+
+func (t *CacheTree) subtree(name string, create bool) *CacheTree {
+	for _, sub := range t.Subtrees {
+		if sub.Name == name {
+			return sub
+		}
+	}
+	if !create {
+		return nil
+	}
+	sub := &CacheTree{Name: name, Entries: -1}
+	t.Subtrees = append(t.Subtrees, sub)
+	return sub
+}
+
+// Find returns the cached tree for the directory at path, or nil if there is none.
+func (t *CacheTree) Find(path string) *CacheTree {
+	for _, name := range strings.Split(strings.Trim(path, "/"), "/") {
+		if name == "" || t == nil {
+			continue
+		}
+		t = t.subtree(name, false)
+	}
+	return t
+}
+
+// invalidate marks every directory leading up to path as changed.
+func (t *CacheTree) invalidate(path string) {
+	t.Entries = -1
+	dir, rest, ok := strings.Cut(path, "/")
+	if !ok {
+		return
+	}
+	if sub := t.subtree(dir, false); sub != nil {
+		sub.invalidate(rest)
+	}
+}
+
+// Invalidate drops the cached trees containing path. It must be called for every entry
+// that is added, removed or changed in an index that is written back.
+func (i *Index) Invalidate(path string) {
+	if i.Tree != nil {
+		i.Tree.invalidate(path)
+	}
+}
+
+// updateCacheTree writes the tree for the entries below base, which start at entries[0],
+// reusing any subtree that is still valid. It returns the number of entries covered.
+func (r *Repository) updateCacheTree(t *CacheTree, entries []IndexEntry, base string) (int, error) {
+	if t.Entries >= 0 {
+		return t.Entries, nil
+	}
+
+	leaves := make([]TreeLeaf, 0)
+	used := make([]*CacheTree, 0, len(t.Subtrees))
+	i := 0
+	for i < len(entries) && strings.HasPrefix(entries[i].Name, base) {
+		entry := entries[i]
+		name := entry.Name[len(base):]
+
+		if dir, _, ok := strings.Cut(name, "/"); ok {
+			sub := t.subtree(dir, true)
+			n, err := r.updateCacheTree(sub, entries[i:], base+dir+"/")
+			if err != nil {
+				return 0, err
+			}
+			i += n
+			used = append(used, sub)
+			// a directory holding only intent-to-add entries has nothing to record
+			if sub.Sha != emptyTreeSha {
+				leaves = append(leaves, TreeLeaf{Mode: ModeDir, Path: dir, Sha: sub.Sha})
+			}
+			continue
+		}
+
+		i++
+		// intent-to-add entries only reserve the path, they have no content yet
+		if entry.IntentToAdd {
+			continue
+		}
+		leaves = append(leaves, TreeLeaf{
+			Mode: FileMode(entry.Mode()),
+			Path: name,
+			Sha:  entry.Sha,
+		})
+	}
+
+	tree, err := BuildTree(leaves)
+	if err != nil {
+		return 0, err
+	}
+	sha, err := Write(tree, r)
+	if err != nil {
+		return 0, err
+	}
+	t.Sha = sha
+	t.Entries = i
+	t.Subtrees = slices.DeleteFunc(t.Subtrees, func(sub *CacheTree) bool {
+		return !slices.Contains(used, sub)
+	})
+	return i, nil
+}
+
+const emptyTreeSha = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
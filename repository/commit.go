@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var objectNameRe = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// ValidObjectName reports whether name is a full object name as it is recorded in
+// objects: 40 lowercase hex digits.
+func ValidObjectName(name string) bool {
+	return objectNameRe.MatchString(name)
+}
+
+func (c *Commit) header(key string) string {
+	values, _ := c.Kvlm.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Tree is the name of the tree the commit records.
+func (c *Commit) Tree() string {
+	return c.header("tree")
+}
+
+// Parents are the names of the parent commits, in order. A root commit has none.
+func (c *Commit) Parents() []string {
+	parents, _ := c.Kvlm.Get("parent")
+	return parents
+}
+
+func (c *Commit) Author() (Signature, error) {
+	return ParseSignature(c.header("author"))
+}
+
+func (c *Commit) Committer() (Signature, error) {
+	return ParseSignature(c.header("committer"))
+}
+
+func (c *Commit) Message() string {
+	return string(c.Kvlm.Message)
+}
+
+// Subject is the first line of the message.
+func (c *Commit) Subject() string {
+	subject, _, _ := strings.Cut(strings.TrimSpace(c.Message()), "\n")
+	return subject
+}
+
+// Body is the message after the subject and the blank lines that follow it.
+func (c *Commit) Body() string {
+	_, body, _ := strings.Cut(strings.TrimSpace(c.Message()), "\n")
+	return strings.TrimLeft(body, "\n")
+}
+
+func (t *Tag) header(key string) string {
+	values, _ := t.Kvlm.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Target is the name of the object the tag points at.
+func (t *Tag) Target() string {
+	return t.header("object")
+}
+
+// TargetType is the type of the object the tag points at, e.g. "commit".
+func (t *Tag) TargetType() string {
+	return t.header("type")
+}
+
+func (t *Tag) Name() string {
+	return t.header("tag")
+}
+
+func (t *Tag) Tagger() (Signature, error) {
+	return ParseSignature(t.header("tagger"))
+}
+
+// Message is the tag message, with the signature of a signed tag at its end.
+func (t *Tag) Message() string {
+	return string(t.Kvlm.Message)
+}
+
+// BuildCommit makes a commit of tree with the given parents, checking that the object
+// names and identities can be recorded.
+func BuildCommit(tree string, parents []string, author, committer Signature, message string) (*Commit, error) {
+	for _, name := range append([]string{tree}, parents...) {
+		if !ValidObjectName(name) {
+			return nil, fmt.Errorf("not a valid object name %s", name)
+		}
+	}
+	if err := author.validate(); err != nil {
+		return nil, err
+	}
+	if err := committer.validate(); err != nil {
+		return nil, err
+	}
+
+	commit := &Commit{}
+	commit.Kvlm.Set("tree", []string{tree})
+	if len(parents) != 0 {
+		commit.Kvlm.Set("parent", parents)
+	}
+	commit.Kvlm.Set("author", []string{author.String()})
+	commit.Kvlm.Set("committer", []string{committer.String()})
+	commit.Kvlm.Message = []byte(message)
+	return commit, nil
+}
+
+// BuildTag makes an annotated tag called name of the object of the given type.
+func BuildTag(object, objectType, name string, tagger Signature, message string) (*Tag, error) {
+	if !ValidObjectName(object) {
+		return nil, fmt.Errorf("not a valid object name %s", object)
+	}
+	if !slices.Contains([]string{"blob", "tree", "commit", "tag"}, objectType) {
+		return nil, fmt.Errorf("invalid object type %s", objectType)
+	}
+	if name == "" || strings.ContainsAny(name, " \n") {
+		return nil, fmt.Errorf("'%s' is not a valid tag name.", name)
+	}
+	if err := tagger.validate(); err != nil {
+		return nil, err
+	}
+
+	tag := &Tag{}
+	tag.Kvlm.Set("object", []string{object})
+	tag.Kvlm.Set("type", []string{objectType})
+	tag.Kvlm.Set("tag", []string{name})
+	tag.Kvlm.Set("tagger", []string{tagger.String()})
+	tag.Kvlm.Message = []byte(message)
+	return tag, nil
+}
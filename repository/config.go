@@ -1,9 +1,12 @@
 package repository
 
 import (
+	"cmp"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"gopkg.in/ini.v1"
 )
@@ -12,36 +15,77 @@ type Config struct {
 	file *ini.File
 }
 
-func ReadConfig() (*Config, error) {
-	xdgConfigHome := "~/.config"
-	if configHome, ok := os.LookupEnv("XDG_CONFIG_HOME"); ok {
-		xdgConfigHome = configHome
+// ReadConfig merges the system, global and repository configuration, later files taking
+// precedence like they do for git. repo may be nil outside of a repository.
+func ReadConfig(repo *Repository) (*Config, error) {
+	sources := make([]any, 0)
+	if _, ok := os.LookupEnv("GIT_CONFIG_NOSYSTEM"); !ok {
+		sources = append(sources, cmp.Or(os.Getenv("GIT_CONFIG_SYSTEM"), "/etc/gitconfig"))
 	}
-
-	configs := make([]any, 0)
-	if _, err := os.Stat("~/.gitconfig"); err == nil {
-		configs = append(configs, "~/.gitconfig")
+	if global, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+		sources = append(sources, global)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		xdgConfigHome := cmp.Or(os.Getenv("XDG_CONFIG_HOME"), filepath.Join(home, ".config"))
+		sources = append(sources, filepath.Join(xdgConfigHome, "git", "config"), filepath.Join(home, ".gitconfig"))
+	}
+	if repo != nil {
+		sources = append(sources, repo.Path("config"))
+	}
+	if len(sources) == 0 {
+		return &Config{ini.Empty()}, nil
 	}
 
-	config, err := ini.Load(
-		os.ExpandEnv(filepath.Join(xdgConfigHome, "git/config")),
-		configs...,
-	)
+	// git quotes values that hold comment characters, like trailer.separators = ":#"
+	config, err := ini.LoadSources(ini.LoadOptions{Loose: true, UnescapeValueDoubleQuotes: true}, sources[0], sources[1:]...)
 	if err != nil {
 		return nil, fmt.Errorf("cannot parse config files")
 	}
 
-	return &Config{config}, err
+	return &Config{config}, nil
+}
+
+// Get returns the value of section.key, or an empty string if it isn't set. Like in git,
+// section and key names are case-insensitive, and a subsection is given as
+// "section.subsection", e.g. "gpg.ssh" for [gpg "ssh"].
+func (c *Config) Get(section, key string) string {
+	name, sub, hasSub := strings.Cut(section, ".")
+	value := ""
+	for _, s := range c.file.Sections() {
+		n, quoted, ok := strings.Cut(s.Name(), " ")
+		if !strings.EqualFold(n, name) || ok != hasSub || ok && strings.Trim(quoted, `"`) != sub {
+			continue
+		}
+		for _, k := range s.Keys() {
+			if strings.EqualFold(k.Name(), key) {
+				value = k.String()
+			}
+		}
+	}
+	return value
 }
 
-func (c *Config) User() string {
-	if user, err := c.file.GetSection("user"); err == nil {
-		name, nErr := user.GetKey("name")
-		email, eErr := user.GetKey("email")
-		if nErr == nil && eErr == nil {
-			return fmt.Sprintf("%s <%s>", name, email)
+// Subsections lists the subsections of section in the order they first appear, e.g. the
+// names of the [trailer "<name>"] sections.
+func (c *Config) Subsections(section string) []string {
+	ret := make([]string, 0)
+	for _, s := range c.file.Sections() {
+		n, quoted, ok := strings.Cut(s.Name(), " ")
+		if !ok || !strings.EqualFold(n, section) {
+			continue
+		}
+		if sub := strings.Trim(quoted, `"`); !slices.Contains(ret, sub) {
+			ret = append(ret, sub)
 		}
 	}
+	return ret
+}
 
-	return ""
+// ExpandPath expands a leading "~/" of a path from the config to the home directory.
+func ExpandPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
 }
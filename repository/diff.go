@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type EditKind int
+
+const (
+	EditEqual EditKind = iota
+	EditDelete
+	EditInsert
+)
+
+type Edit struct {
+	Kind    EditKind
+	OldLine int
+	NewLine int
+	Text    string
+}
+
+func SplitLines(data []byte) []string {
+	if len(data) == 0 {
+		return []string{}
+	}
+
+	lines := make([]string, 0, bytes.Count(data, []byte{'\n'})+1)
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+
+	return lines
+}
+
+// DiffLines computes a shortest edit script between a and b using Myers' algorithm.
+func DiffLines(a, b []string) []Edit {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return []Edit{}
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := make([][]int, 0)
+
+outer:
+	for d := 0; d <= max; d++ {
+		// step d only reads the diagonals -d-1 through d+1 of the previous step
+		trace = append(trace, slices.Clone(v[offset-d-1:offset+d+2]))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break outer
+			}
+		}
+	}
+
+	edits := make([]Edit, 0, max)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		offset := d + 1
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			edits = append(edits, Edit{EditEqual, x - 1, y - 1, a[x-1]})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, Edit{EditInsert, x, y - 1, b[y-1]})
+			} else {
+				edits = append(edits, Edit{EditDelete, x - 1, y, a[x-1]})
+			}
+		}
+
+		x, y = prevX, prevY
+	}
+
+	slices.Reverse(edits)
+	return edits
+}
+
+// lineMatches maps every line of a to the line of b it is kept as, or -1.
+func lineMatches(a, b []string) []int {
+	ret := make([]int, len(a))
+	for i := range ret {
+		ret[i] = -1
+	}
+
+	for _, e := range DiffLines(a, b) {
+		if e.Kind == EditEqual {
+			ret[e.OldLine] = e.NewLine
+		}
+	}
+
+	return ret
+}
+
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Edits    []Edit
+}
+
+// Hunks groups an edit script into unified diff hunks with the given lines of context.
+func Hunks(edits []Edit, context int) []Hunk {
+	ret := make([]Hunk, 0)
+
+	changes := make([]int, 0)
+	for i, e := range edits {
+		if e.Kind != EditEqual {
+			changes = append(changes, i)
+		}
+	}
+
+	for i := 0; i < len(changes); {
+		start := max(changes[i]-context, 0)
+		end := changes[i]
+		for i < len(changes) && changes[i] <= end+2*context+1 {
+			end = changes[i]
+			i++
+		}
+		end = min(end+context+1, len(edits))
+
+		hunk := NewHunk(edits[start:end])
+		ret = append(ret, hunk)
+	}
+
+	return ret
+}
+
+// NewHunk computes the line ranges of a contiguous slice of an edit script.
+func NewHunk(edits []Edit) Hunk {
+	hunk := Hunk{Edits: edits}
+	oldStart, newStart := -1, -1
+	for _, e := range hunk.Edits {
+		switch e.Kind {
+		case EditEqual:
+			hunk.OldLines++
+			hunk.NewLines++
+		case EditDelete:
+			hunk.OldLines++
+		case EditInsert:
+			hunk.NewLines++
+		}
+		if oldStart < 0 && e.Kind != EditInsert {
+			oldStart = e.OldLine
+		}
+		if newStart < 0 && e.Kind != EditDelete {
+			newStart = e.NewLine
+		}
+	}
+
+	// an empty range starts at the line preceding the change
+	first := hunk.Edits[0]
+	if oldStart < 0 {
+		oldStart = first.OldLine - 1
+	}
+	if newStart < 0 {
+		newStart = first.NewLine - 1
+	}
+	hunk.OldStart = oldStart + 1
+	hunk.NewStart = newStart + 1
+
+	return hunk
+}
+
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+func (h Hunk) String() string {
+	var b strings.Builder
+	b.WriteString(h.Header())
+	b.WriteByte('\n')
+	for _, e := range h.Edits {
+		switch e.Kind {
+		case EditEqual:
+			b.WriteByte(' ')
+		case EditDelete:
+			b.WriteByte('-')
+		case EditInsert:
+			b.WriteByte('+')
+		}
+		b.WriteString(e.Text)
+		if !strings.HasSuffix(e.Text, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func FuzzDiffLines(f *testing.F) {
+	f.Add("a\nb\nc\n", "a\nc\nd\n")
+	f.Add("", "a\n")
+	f.Add("a\nb\n", "")
+	f.Add("a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n")
+
+	f.Fuzz(func(t *testing.T, old, new string) {
+		a, b := SplitLines([]byte(old)), SplitLines([]byte(new))
+		var gotA, gotB strings.Builder
+		for _, e := range DiffLines(a, b) {
+			switch e.Kind {
+			case EditEqual:
+				if a[e.OldLine] != b[e.NewLine] {
+					t.Fatalf("equal edit of differing lines %q and %q", a[e.OldLine], b[e.NewLine])
+				}
+				gotA.WriteString(e.Text)
+				gotB.WriteString(e.Text)
+			case EditDelete:
+				gotA.WriteString(e.Text)
+			case EditInsert:
+				gotB.WriteString(e.Text)
+			}
+		}
+		if gotA.String() != old || gotB.String() != new {
+			t.Errorf("DiffLines(%q, %q) rebuilds %q and %q", old, new, gotA.String(), gotB.String())
+		}
+	})
+}
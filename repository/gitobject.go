@@ -24,10 +24,12 @@ type Commit struct {
 	Kvlm KvlmData
 }
 
-func NewCommit(data []byte) *Commit {
-	return &Commit{
-		Kvlm: kvlmParse(data, 0, nil),
+func NewCommit(data []byte) (*Commit, error) {
+	kvlm, err := kvlmParse(data)
+	if err != nil {
+		return nil, err
 	}
+	return &Commit{Kvlm: kvlm}, nil
 }
 
 func (gc *Commit) Serialize() []byte {
@@ -42,19 +44,24 @@ type Tree struct {
 	Items []TreeLeaf
 }
 
-func NewTree(data []byte) *Tree {
-	return &Tree{
-		Items: TreeParse(data),
+func NewTree(data []byte) (*Tree, error) {
+	items, err := TreeParse(data)
+	if err != nil {
+		return nil, err
 	}
+	return &Tree{Items: items}, nil
 }
 
-func (gc *Tree) Serialize() []byte {
-	items := slices.Clone(gc.Items)
-	slices.SortFunc(items, treeLeafSort)
+// Sort puts the items in the order git requires of a tree. Items are serialized in the
+// order they are in, so that a tree that was read writes back the same.
+func (gc *Tree) Sort() {
+	slices.SortFunc(gc.Items, treeLeafSort)
+}
 
+func (gc *Tree) Serialize() []byte {
 	ret := make([]byte, 0)
-	for _, i := range items {
-		ret = append(ret, i.Mode...)
+	for _, i := range gc.Items {
+		ret = append(ret, i.serializedMode()...)
 		ret = append(ret, ' ')
 		ret = append(ret, []byte(i.Path)...)
 		ret = append(ret, '\x00')
@@ -73,13 +80,19 @@ func (gc *Tree) Fmt() []byte {
 }
 
 type Tag struct {
-	*Commit
+	Kvlm KvlmData
 }
 
-func NewTag(data []byte) *Tag {
-	return &Tag{
-		Commit: NewCommit(data),
+func NewTag(data []byte) (*Tag, error) {
+	kvlm, err := kvlmParse(data)
+	if err != nil {
+		return nil, err
 	}
+	return &Tag{Kvlm: kvlm}, nil
+}
+
+func (gc *Tag) Serialize() []byte {
+	return gc.Kvlm.Serialize()
 }
 
 func (gc *Tag) Fmt() []byte {
@@ -130,28 +143,65 @@ func ReadObj(repo *Repository, sha string) (GitObject, error) {
 		return nil, fmt.Errorf("Cannot read object: %s\n", sha)
 	}
 
-	x := bytes.Index(raw, []byte{' '})
+	x := bytes.IndexByte(raw, ' ')
+	y := bytes.IndexByte(raw, '\x00')
+	if x < 0 || y < x {
+		return nil, fmt.Errorf("malformed object %s: bad header", sha)
+	}
 	format := raw[:x]
 
-	y := bytes.Index(raw, []byte{'\x00'})
 	size, err := strconv.Atoi(string(raw[x+1 : y]))
 	if err != nil || size != len(raw)-y-1 {
 		return nil, fmt.Errorf("malformed object %s: bad length", sha)
 	}
 
-	data := raw[y+1:]
-	switch string(format) {
+	obj, err := ParseObject(string(format), raw[y+1:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed object %s: %v", sha, err)
+	}
+	return obj, nil
+}
+
+// ParseObject parses the data of an object of the given type.
+func ParseObject(format string, data []byte) (GitObject, error) {
+	switch format {
 	case "commit":
-		return NewCommit(data), nil
+		commit, err := NewCommit(data)
+		if err != nil {
+			return nil, err
+		}
+		return commit, nil
 	case "tree":
-		return NewTree(data), nil
+		tree, err := NewTree(data)
+		if err != nil {
+			return nil, err
+		}
+		return tree, nil
 	case "tag":
-		return NewTag(data), nil
+		tag, err := NewTag(data)
+		if err != nil {
+			return nil, err
+		}
+		return tag, nil
 	case "blob":
 		return NewBlob(data), nil
 	default:
-		return nil, fmt.Errorf("unknown type %s for object %s", string(format), sha)
+		return nil, fmt.Errorf("unknown type %s", format)
+	}
+}
+
+func ReadCommit(repo *Repository, sha string) (*Commit, error) {
+	obj, err := ReadObj(repo, sha)
+	if err != nil {
+		return nil, err
 	}
+
+	commit, ok := obj.(*Commit)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a commit", sha)
+	}
+
+	return commit, nil
 }
 
 func Write(obj GitObject, repo *Repository) (string, error) {
@@ -231,19 +281,17 @@ func find(repo *Repository, name string, format string, follow bool) (string, er
 
 		switch obj := obj.(type) {
 		case *Tag:
-			tagObj, ok := obj.Kvlm.Get("object")
-			if !ok || len(tagObj) == 0 {
+			sha = obj.Target()
+			if sha == "" {
 				return "", fmt.Errorf("no object found")
 			}
-			sha = tagObj[0]
 			continue
 		case *Commit:
 			if format == "tree" {
-				treeObj, ok := obj.Kvlm.Get("tree")
-				if !ok || len(treeObj) == 0 {
+				sha = obj.Tree()
+				if sha == "" {
 					return "", fmt.Errorf("no object found")
 				}
-				sha = treeObj[0]
 				continue
 			}
 		}
@@ -261,29 +309,65 @@ func FindNoFollow(repo *Repository, name string, format string) (string, error)
 }
 
 func TreeToDict(repo *Repository, ref string, prefix string) (map[string]string, error) {
+	leaves, err := TreeToLeaves(repo, ref, prefix)
+	if err != nil {
+		return nil, err
+	}
+
 	ret := make(map[string]string)
+	for path, leaf := range leaves {
+		ret[path] = leaf.Sha
+	}
+
+	return ret, nil
+}
+
+// TreeToModes maps the paths of a flattened tree to their modes, e.g. 0o100755.
+func TreeToModes(repo *Repository, ref string, prefix string) (map[string]int, error) {
+	leaves, err := TreeToLeaves(repo, ref, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]int)
+	for path, leaf := range leaves {
+		ret[path] = int(leaf.Mode)
+	}
+
+	return ret, nil
+}
+
+func TreeToLeaves(repo *Repository, ref string, prefix string) (map[string]TreeLeaf, error) {
+	ret := make(map[string]TreeLeaf)
 	treeSha, err := ObjectFind(repo, ref, "tree")
 	if err != nil {
 		return nil, err
 	}
+	if treeSha == "" {
+		return nil, fmt.Errorf("%s is not a tree", ref)
+	}
 	tree, err := ReadObj(repo, treeSha)
 	if err != nil {
 		return nil, err
 	}
 
-	treeObj := tree.(*Tree)
+	treeObj, ok := tree.(*Tree)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a tree", treeSha)
+	}
 
 	for _, leaf := range treeObj.Items {
 		fullPath := filepath.Join(prefix, leaf.Path)
-		if bytes.HasPrefix(leaf.Mode, []byte("04")) {
-			subMap, err := TreeToDict(repo, leaf.Sha, fullPath)
+		if leaf.Mode.IsDir() {
+			subMap, err := TreeToLeaves(repo, leaf.Sha, fullPath)
 			if err != nil {
 				return nil, err
 			}
 
 			maps.Copy(ret, subMap)
 		} else {
-			ret[fullPath] = leaf.Sha
+			leaf.Path = fullPath
+			ret[fullPath] = leaf
 		}
 	}
 
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signature is the identity and time recorded in an author, committer or tagger line.
+type Signature struct {
+	Name  string
+	Email string
+	When  time.Time
+}
+
+// String formats the signature as it is recorded in commits and tags.
+func (s Signature) String() string {
+	return fmt.Sprintf("%s <%s> %d %s", s.Name, s.Email, s.When.Unix(), s.When.Format("-0700"))
+}
+
+func (s Signature) validate() error {
+	if strings.ContainsAny(s.Name, "<>\n") || strings.ContainsAny(s.Email, "<>\n") {
+		return fmt.Errorf("invalid ident '%s <%s>'", s.Name, s.Email)
+	}
+	return nil
+}
+
+var signatureRe = regexp.MustCompile(`^(.*?) <([^<>]*)> ([0-9]+) ([+-][0-9]{4})$`)
+
+// ParseSignature reads a signature back from an author, committer or tagger line.
+func ParseSignature(line string) (Signature, error) {
+	m := signatureRe.FindStringSubmatch(line)
+	if m == nil {
+		return Signature{}, fmt.Errorf("malformed ident line '%s'", line)
+	}
+	unix, err := strconv.ParseInt(m[3], 10, 64)
+	if err != nil {
+		return Signature{}, fmt.Errorf("malformed ident line '%s'", line)
+	}
+	zone, err := time.Parse("-0700", m[4])
+	if err != nil {
+		return Signature{}, fmt.Errorf("malformed ident line '%s'", line)
+	}
+	return Signature{m[1], m[2], time.Unix(unix, 0).In(zone.Location())}, nil
+}
@@ -25,26 +25,89 @@ type IndexBinaryEntry struct {
 }
 
 type IndexEntry struct {
-	Ctime       time.Time
-	Mtime       time.Time
-	Dev         int
-	Ino         int
-	ModeType    int
-	ModePerms   int
-	Uid         int
-	Gid         int
-	Fsize       int
-	Sha         string
-	AssumeValid bool
-	Stage       int
-	Name        string
+	Ctime        time.Time
+	Mtime        time.Time
+	Dev          int
+	Ino          int
+	ModeType     int
+	ModePerms    int
+	Uid          int
+	Gid          int
+	Fsize        int
+	Sha          string
+	AssumeValid  bool
+	SkipWorktree bool
+	IntentToAdd  bool
+	Stage        int
+	Name         string
+}
+
+const (
+	indexSkipWorktree uint16 = 0x1 << 14
+	indexIntentToAdd  uint16 = 0x1 << 13
+)
+
+func (e IndexEntry) extended() bool {
+	return e.SkipWorktree || e.IntentToAdd
+}
+
+// indexVarint decodes the offset encoding used for prefix-compressed names in version 4
+// indexes, returning the value and the number of bytes read (0 if malformed).
+func indexVarint(data []byte) (int, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	value := int(data[0] & 0x7f)
+	n := 1
+	for data[n-1]&0x80 != 0 {
+		if n == len(data) {
+			return 0, 0
+		}
+		value = ((value + 1) << 7) | int(data[n]&0x7f)
+		n++
+	}
+	return value, n
+}
+
+func appendIndexVarint(buf []byte, value int) []byte {
+	var tmp [16]byte
+	pos := len(tmp) - 1
+	tmp[pos] = byte(value & 0x7f)
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		pos--
+		tmp[pos] = 0x80 | byte(value&0x7f)
+	}
+	return append(buf, tmp[pos:]...)
+}
+
+// IndexExtension is an optional index extension that wyog does not interpret but keeps
+// when rewriting the index.
+type IndexExtension struct {
+	Signature string
+	Data      []byte
 }
 
 type Index struct {
-	Version int
-	Entries []IndexEntry
+	Version    int
+	Entries    []IndexEntry
+	Extensions []IndexExtension
+	Tree       *CacheTree
+
+	// timestamp is the modification time of the index file when it was read
+	timestamp time.Time
+}
+
+// racy reports whether entry's file was modified no earlier than the index was written,
+// so a later change within the same timestamp would go unnoticed by its stat data.
+func (i *Index) racy(entry IndexEntry) bool {
+	return !i.timestamp.IsZero() && !entry.Mtime.Before(i.timestamp)
 }
 
+// staleExtensions describe the entries themselves, so they are dropped rather than
+// written back once the entries may have changed.
+var staleExtensions = map[string]bool{"UNTR": true, "FSMN": true, "EOIE": true, "IEOT": true}
+
 func NewIndexV2(entries []IndexEntry) Index {
 	if entries == nil {
 		entries = make([]IndexEntry, 0)
@@ -55,3 +118,13 @@ func NewIndexV2(entries []IndexEntry) Index {
 		Entries: entries,
 	}
 }
+
+// Mode returns the entry's mode as it is written to trees, e.g. 0o100755.
+func (e IndexEntry) Mode() int {
+	return e.ModeType<<12 | e.ModePerms
+}
+
+func (e *IndexEntry) SetMode(mode int) {
+	e.ModeType = mode >> 12
+	e.ModePerms = mode & 0o777
+}
@@ -2,71 +2,124 @@ package repository
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
-
-	"github.com/kbraun9118/wyog/util"
 )
 
+// KvlmHeader is a single header line of a commit or tag, with continuation lines joined.
+type KvlmHeader struct {
+	Key   string
+	Value string
+}
+
+// KvlmData holds the headers and message of a commit or tag. The headers keep the order
+// and repetitions they were read with, so that an object serializes back to the same bytes.
+// A nil Message is an object that ends after its headers, without the blank line.
 type KvlmData struct {
-	*util.LinkedMap[string, []string]
+	Headers []KvlmHeader
 	Message []byte
 }
 
-func kvlmParse(raw []byte, start int, headers *util.LinkedMap[string, []string]) KvlmData {
-	if headers == nil {
-		headers = util.NewLinkedMap[string, []string]()
-	}
+func kvlmParse(raw []byte) (KvlmData, error) {
+	ret := KvlmData{Headers: make([]KvlmHeader, 0)}
 
-	spc := start + bytes.Index(raw[start:], []byte(" "))
-	n1 := start + bytes.Index(raw[start:], []byte("\n"))
+	pos := 0
+	for {
+		if pos >= len(raw) {
+			return ret, nil
+		}
+		if raw[pos] == '\n' {
+			ret.Message = raw[pos+1:]
+			return ret, nil
+		}
 
-	if spc < 0 || n1 < spc {
-		if n1 != start {
-			panic("n1 and start should equal")
+		// a header runs until a newline that is not followed by a continuation line
+		end := pos
+		for {
+			i := bytes.IndexByte(raw[end:], '\n')
+			if i < 0 {
+				return KvlmData{}, fmt.Errorf("unterminated header")
+			}
+			end += i
+			if end+1 >= len(raw) || raw[end+1] != ' ' {
+				break
+			}
+			end++
 		}
 
-		return KvlmData{
-			LinkedMap: headers,
-			Message:   raw[start+1:],
+		key, value, ok := bytes.Cut(raw[pos:end], []byte(" "))
+		if !ok || len(key) == 0 || bytes.IndexByte(key, '\n') >= 0 {
+			return KvlmData{}, fmt.Errorf("malformed header '%s'", raw[pos:end])
 		}
+		ret.Headers = append(ret.Headers, KvlmHeader{
+			Key:   string(key),
+			Value: string(bytes.ReplaceAll(value, []byte("\n "), []byte("\n"))),
+		})
+		pos = end + 1
 	}
+}
 
-	key := raw[start:spc]
-
-	end := start
-
-	for {
-		end = end + 1 + bytes.Index(raw[end+1:], []byte("\n"))
-		if raw[end+1] != ' ' {
-			break
+// Get returns the values of the headers named key, in order.
+func (kvlm KvlmData) Get(key string) ([]string, bool) {
+	values := make([]string, 0)
+	for _, h := range kvlm.Headers {
+		if h.Key == key {
+			values = append(values, h.Value)
 		}
 	}
+	return values, len(values) != 0
+}
 
-	value := bytes.ReplaceAll(raw[spc+1:end], []byte("\n "), []byte("\n"))
-
-	if v, ok := headers.Get(string(key)); ok {
-		headers.Set(string(key), append(v, string(value)))
-	} else {
-		headers.Set(string(key), []string{string(value)})
+// Set replaces the headers named key with one header per value, where the first of them
+// was, or at the end for a new key.
+func (kvlm *KvlmData) Set(key string, values []string) {
+	headers := make([]KvlmHeader, 0, len(kvlm.Headers)+len(values))
+	added := false
+	for _, h := range kvlm.Headers {
+		if h.Key != key {
+			headers = append(headers, h)
+			continue
+		}
+		if !added {
+			for _, v := range values {
+				headers = append(headers, KvlmHeader{key, v})
+			}
+			added = true
+		}
 	}
+	if !added {
+		for _, v := range values {
+			headers = append(headers, KvlmHeader{key, v})
+		}
+	}
+	kvlm.Headers = headers
+}
 
-	return kvlmParse(raw, end+1, headers)
+// Without returns a copy of the data without the headers named key.
+func (kvlm KvlmData) Without(key string) KvlmData {
+	ret := KvlmData{Headers: make([]KvlmHeader, 0, len(kvlm.Headers)), Message: kvlm.Message}
+	for _, h := range kvlm.Headers {
+		if h.Key != key {
+			ret.Headers = append(ret.Headers, h)
+		}
+	}
+	return ret
 }
 
 func (kvlm KvlmData) Serialize() []byte {
 	ret := make([]byte, 0)
 
-	for k, val := range kvlm.Iterate() {
-		for _, v := range val {
-			ret = append(ret, []byte(k)...)
-			ret = append(ret, ' ')
-			ret = append(ret, []byte(strings.ReplaceAll(v, "\n", "\n "))...)
-			ret = append(ret, '\n')
-		}
+	for _, h := range kvlm.Headers {
+		ret = append(ret, []byte(h.Key)...)
+		ret = append(ret, ' ')
+		ret = append(ret, []byte(strings.ReplaceAll(h.Value, "\n", "\n "))...)
+		ret = append(ret, '\n')
 	}
 
-	ret = append(ret, '\n')
-	ret = append(ret, kvlm.Message...)
+	if kvlm.Message != nil {
+		ret = append(ret, '\n')
+		ret = append(ret, kvlm.Message...)
+	}
 
 	return ret
 }
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"bytes"
+	"testing"
+)
+
+func FuzzKvlm(f *testing.F) {
+	f.Add([]byte("tree 29ff16c9c14e2652b22f8b78bb08a5a07930c147\n" +
+		"parent 206941306e8a8af65b66eaaaea388a7ae24d49a0\n" +
+		"author Test User <test@example.com> 1527025023 +0200\n" +
+		"committer Test User <test@example.com> 1527025044 +0200\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n" +
+		" \n" +
+		" iQIzBAABCAAdFiEExwXquOM8bWb4Q2zVGxM2FxoLkGQFAlsEjZQACgkQGxM2FxoL\n" +
+		" -----END PGP SIGNATURE-----\n" +
+		"\n" +
+		"Create first draft\n"))
+	f.Add([]byte("object 29ff16c9c14e2652b22f8b78bb08a5a07930c147\n" +
+		"type commit\n" +
+		"tag v1\n" +
+		"tagger Test User <test@example.com> 1527025023 +0200\n" +
+		"\n" +
+		"First release\n"))
+	f.Add([]byte("tree 29ff16c9c14e2652b22f8b78bb08a5a07930c147\n\n"))
+	f.Add([]byte("tree 29ff16c9c14e2652b22f8b78bb08a5a07930c147\n"))
+	f.Add([]byte("key value\n  indented continuation\n\nmessage"))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		kvlm, err := kvlmParse(raw)
+		if err != nil {
+			return
+		}
+		if got := kvlm.Serialize(); !bytes.Equal(got, raw) {
+			t.Errorf("Serialize(kvlmParse(%q)) = %q", raw, got)
+		}
+	})
+}
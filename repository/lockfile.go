@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// staleLockAge is how old a lock file must be before it is assumed to have been left
+// behind by a process that died. Commands hold a lock from reading the file until they
+// write it back, which for commit includes the time spent in the editor, so a lock that
+// was taken over is detected by Commit.
+const staleLockAge = 10 * time.Minute
+
+// LockFile guards a file in the git directory. The new contents are written to
+// "<file>.lock", which only replaces the file once Commit is called, so readers
+// never see a partially written file.
+type LockFile struct {
+	path string
+	file *os.File
+}
+
+var (
+	heldLocks       = make(map[string]bool)
+	heldLocksMu     sync.Mutex
+	watchSignalOnce sync.Once
+)
+
+// removeLocksOnSignal removes the lock files that are still held when the process is
+// killed by a signal, e.g. interrupted while commit waits for the editor, so that they
+// don't block the next command.
+func removeLocksOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGPIPE)
+	go func() {
+		sig := <-signals
+		heldLocksMu.Lock()
+		for path := range heldLocks {
+			os.Remove(path)
+		}
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		os.Exit(code)
+	}()
+}
+
+func holdLock(path string, held bool) {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	if held {
+		heldLocks[path] = true
+	} else {
+		delete(heldLocks, path)
+	}
+}
+
+// Lock takes the lock for the given file in the git directory. It fails if another
+// process already holds it, unless the lock is stale.
+func (r *Repository) Lock(path ...string) (*LockFile, error) {
+	target, err := r.File(path...)
+	if err != nil {
+		return nil, err
+	}
+	lockPath := *target + ".lock"
+
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		if stat, statErr := os.Stat(lockPath); statErr == nil && time.Since(stat.ModTime()) > staleLockAge {
+			fmt.Fprintf(os.Stderr, "warning: removing stale lock file '%s'\n", lockPath)
+			if err := os.Remove(lockPath); err != nil {
+				return nil, fmt.Errorf("unable to remove stale lock file '%s': %v", lockPath, err)
+			}
+			file, err = os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		}
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return nil, fmt.Errorf(`unable to create '%s': File exists.
+
+Another wyog process seems to be running in this repository and
+holds the lock, e.g. 'wyog commit' waiting for its message to be
+edited. Please make sure all processes are terminated then try
+again. If it still fails, a wyog process may have crashed in this
+repository earlier: remove the file manually to continue.`, lockPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create '%s': %v", lockPath, err)
+	}
+
+	watchSignalOnce.Do(removeLocksOnSignal)
+	holdLock(lockPath, true)
+	return &LockFile{path: *target, file: file}, nil
+}
+
+func (l *LockFile) Write(p []byte) (int, error) {
+	return l.file.Write(p)
+}
+
+// Commit flushes the new contents to disk and atomically replaces the locked file.
+func (l *LockFile) Commit() error {
+	if l.file == nil {
+		return fmt.Errorf("lock on '%s' is not held", l.path)
+	}
+	file := l.file
+	l.file = nil
+	defer holdLock(l.path+".lock", false)
+
+	// another process may have removed the lock as stale and taken it itself
+	held, statErr := file.Stat()
+	current, err := os.Stat(l.path + ".lock")
+	if statErr != nil || err != nil || !os.SameFile(held, current) {
+		file.Close()
+		return fmt.Errorf("lock on '%s' was taken over by another process", l.path)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(l.path + ".lock")
+		return fmt.Errorf("unable to write '%s.lock': %v", l.path, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(l.path + ".lock")
+		return fmt.Errorf("unable to write '%s.lock': %v", l.path, err)
+	}
+	if err := os.Rename(l.path+".lock", l.path); err != nil {
+		os.Remove(l.path + ".lock")
+		return fmt.Errorf("unable to rename '%s.lock' to '%s': %v", l.path, l.path, err)
+	}
+	return nil
+}
+
+// Rollback releases the lock without touching the locked file. It does nothing once the
+// lock has been committed or on a nil lock, so it can be deferred right after Lock.
+func (l *LockFile) Rollback() {
+	if l == nil || l.file == nil {
+		return
+	}
+	l.file.Close()
+	os.Remove(l.path + ".lock")
+	holdLock(l.path+".lock", false)
+	l.file = nil
+}
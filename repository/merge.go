@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type MergeLabels struct {
+	Base, Ours, Theirs string
+}
+
+func Merge3(base, ours, theirs []byte, labels MergeLabels) ([]byte, bool) {
+	o := SplitLines(base)
+	a := SplitLines(ours)
+	b := SplitLines(theirs)
+
+	matchA := lineMatches(o, a)
+	matchB := lineMatches(o, b)
+
+	ret := make([]byte, 0, max(len(ours), len(theirs)))
+	conflict := false
+
+	resolve := func(chunkO, chunkA, chunkB []string) {
+		switch {
+		case slices.Equal(chunkA, chunkB), slices.Equal(chunkB, chunkO):
+			ret = appendLines(ret, chunkA)
+		case slices.Equal(chunkA, chunkO):
+			ret = appendLines(ret, chunkB)
+		default:
+			conflict = true
+			ret = append(ret, "<<<<<<< "+labels.Ours+"\n"...)
+			ret = appendLines(ret, chunkA)
+			ret = terminateLine(ret)
+			ret = append(ret, "=======\n"...)
+			ret = appendLines(ret, chunkB)
+			ret = terminateLine(ret)
+			ret = append(ret, ">>>>>>> "+labels.Theirs+"\n"...)
+		}
+	}
+
+	iO, iA, iB := 0, 0, 0
+	for {
+		k := 0
+		for iO+k < len(o) && matchA[iO+k] == iA+k && matchB[iO+k] == iB+k {
+			k++
+		}
+		if k > 0 {
+			ret = appendLines(ret, o[iO:iO+k])
+			iO, iA, iB = iO+k, iA+k, iB+k
+			continue
+		}
+
+		next := -1
+		for i := iO; i < len(o); i++ {
+			if matchA[i] >= 0 && matchB[i] >= 0 {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			if iO < len(o) || iA < len(a) || iB < len(b) {
+				resolve(o[iO:], a[iA:], b[iB:])
+			}
+			break
+		}
+
+		resolve(o[iO:next], a[iA:matchA[next]], b[iB:matchB[next]])
+		iO, iA, iB = next, matchA[next], matchB[next]
+	}
+
+	return ret, conflict
+}
+
+func appendLines(buf []byte, lines []string) []byte {
+	for _, l := range lines {
+		buf = append(buf, l...)
+	}
+	return buf
+}
+
+func terminateLine(buf []byte) []byte {
+	if len(buf) > 0 && buf[len(buf)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	return buf
+}
+
+type MergeConflict struct {
+	Path    string
+	Base    string
+	Ours    string
+	Theirs  string
+	Content []byte
+}
+
+type MergeResult struct {
+	Entries   map[string]string
+	Conflicts []MergeConflict
+}
+
+func (r *Repository) readBlobData(sha string) ([]byte, error) {
+	if sha == "" {
+		return []byte{}, nil
+	}
+
+	obj, err := ReadObj(r, sha)
+	if err != nil {
+		return nil, err
+	}
+	blob, ok := obj.(*Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a blob", sha)
+	}
+
+	return blob.data, nil
+}
+
+// MergeTrees performs a three-way merge of flattened trees as produced by TreeToDict.
+// Cleanly merged paths end up in Entries; everything else is reported as a conflict
+// with the content that should be left in the worktree.
+func (r *Repository) MergeTrees(base, ours, theirs map[string]string, labels MergeLabels) (*MergeResult, error) {
+	paths := make(map[string]bool)
+	for _, m := range []map[string]string{base, ours, theirs} {
+		for p := range m {
+			paths[p] = true
+		}
+	}
+
+	result := &MergeResult{
+		Entries:   make(map[string]string),
+		Conflicts: make([]MergeConflict, 0),
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(paths)) {
+		o, a, b := base[path], ours[path], theirs[path]
+
+		var sha string
+		switch {
+		case a == b:
+			sha = a
+		case a == o:
+			sha = b
+		case b == o:
+			sha = a
+		case a != "" && b != "":
+			baseData, err := r.readBlobData(o)
+			if err != nil {
+				return nil, err
+			}
+			oursData, err := r.readBlobData(a)
+			if err != nil {
+				return nil, err
+			}
+			theirsData, err := r.readBlobData(b)
+			if err != nil {
+				return nil, err
+			}
+
+			merged, conflict := Merge3(baseData, oursData, theirsData, labels)
+			if conflict {
+				result.Conflicts = append(result.Conflicts, MergeConflict{path, o, a, b, merged})
+				continue
+			}
+
+			sha, err = Write(NewBlob(merged), r)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			// modified on one side, deleted on the other: keep the modified content around
+			content, err := r.readBlobData(cmp.Or(a, b))
+			if err != nil {
+				return nil, err
+			}
+			result.Conflicts = append(result.Conflicts, MergeConflict{path, o, a, b, content})
+			continue
+		}
+
+		if sha != "" {
+			result.Entries[path] = sha
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MergeResult) ConflictPaths() string {
+	paths := make([]string, 0, len(m.Conflicts))
+	for _, c := range m.Conflicts {
+		paths = append(paths, c.Path)
+	}
+	return strings.Join(paths, ", ")
+}
@@ -0,0 +1,241 @@
+package repository
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type pathspecItem struct {
+	original string
+	pattern  string
+	exclude  bool
+	glob     bool
+	icase    bool
+	literal  bool
+	re       *regexp.Regexp
+}
+
+// Pathspec implements the subset of git pathspecs shared by the porcelain commands:
+// plain paths and directories, fnmatch wildcards and the exclude, glob, icase, literal
+// and top magic words (including the ":!" and ":/" short forms).
+type Pathspec struct {
+	items []pathspecItem
+}
+
+var pathspecMagic = map[string]bool{"exclude": true, "glob": true, "icase": true, "literal": true, "top": true}
+
+// ParsePathspec parses pathspec arguments given relative to the current directory.
+func (r *Repository) ParsePathspec(args []string) (*Pathspec, error) {
+	spec := &Pathspec{items: make([]pathspecItem, 0, len(args))}
+
+	for _, arg := range args {
+		item := pathspecItem{original: arg}
+		pattern := arg
+		top := false
+
+		switch {
+		case strings.HasPrefix(pattern, ":("):
+			end := strings.Index(pattern, ")")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ')' at the end of pathspec magic in '%s'", arg)
+			}
+			for _, magic := range strings.Split(pattern[2:end], ",") {
+				magic = strings.TrimSpace(magic)
+				if !pathspecMagic[magic] {
+					return nil, fmt.Errorf("invalid pathspec magic '%s' in '%s'", magic, arg)
+				}
+				switch magic {
+				case "exclude":
+					item.exclude = true
+				case "glob":
+					item.glob = true
+				case "icase":
+					item.icase = true
+				case "literal":
+					item.literal = true
+				case "top":
+					top = true
+				}
+			}
+			pattern = pattern[end+1:]
+		case strings.HasPrefix(pattern, ":"):
+			i := 1
+			for ; i < len(pattern); i++ {
+				switch pattern[i] {
+				case '!', '^':
+					item.exclude = true
+				case '/':
+					top = true
+				default:
+					goto done
+				}
+			}
+		done:
+			pattern = strings.TrimPrefix(pattern[i:], ":")
+		}
+
+		if item.glob && item.literal {
+			return nil, fmt.Errorf("'literal' and 'glob' are incompatible in '%s'", arg)
+		}
+
+		if top {
+			pattern = filepath.Clean("/" + pattern)[1:]
+			if len(pattern) == 0 {
+				pattern = "."
+			}
+		} else {
+			if len(pattern) == 0 {
+				pattern = "."
+			}
+			rel, err := r.RelPath(pattern)
+			if err != nil {
+				return nil, err
+			}
+			pattern = rel
+		}
+		item.pattern = filepath.ToSlash(pattern)
+
+		if !item.literal && strings.ContainsAny(item.pattern, "*?[") {
+			re, err := pathspecRegexp(item.pattern, item.glob, item.icase)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pathspec '%s'", arg)
+			}
+			item.re = re
+		}
+
+		spec.items = append(spec.items, item)
+	}
+
+	return spec, nil
+}
+
+func pathspecRegexp(pattern string, glob, icase bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if icase {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+
+	star := ".*"
+	any := "."
+	if glob {
+		star = "[^/]*"
+		any = "[^/]"
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case glob && strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case glob && strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			b.WriteString("/.*")
+			i += 2
+		case c == '*':
+			b.WriteString(star)
+		case c == '?':
+			b.WriteString(any)
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	// a pattern naming a directory matches everything below it
+	b.WriteString("(?:/.*)?$")
+	return regexp.Compile(b.String())
+}
+
+func (i *pathspecItem) match(name string) bool {
+	if i.pattern == "." {
+		return true
+	}
+	if i.re != nil {
+		return i.re.MatchString(name)
+	}
+
+	pattern := i.pattern
+	if i.icase {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+	return name == pattern || strings.HasPrefix(name, pattern+"/")
+}
+
+// Match reports whether a worktree-relative path is selected. A nil or empty pathspec
+// selects everything.
+func (p *Pathspec) Match(name string) bool {
+	if p == nil || len(p.items) == 0 {
+		return true
+	}
+
+	name = filepath.ToSlash(name)
+	included, positive := false, false
+	for _, item := range p.items {
+		if item.exclude {
+			if item.match(name) {
+				return false
+			}
+			continue
+		}
+		positive = true
+		included = included || item.match(name)
+	}
+
+	return included || !positive
+}
+
+func (p *Pathspec) Empty() bool {
+	return p == nil || len(p.items) == 0
+}
+
+// Exact reports whether name was given verbatim, rather than through a directory or wildcard.
+func (p *Pathspec) Exact(name string) bool {
+	if p == nil {
+		return false
+	}
+	for _, item := range p.items {
+		if !item.exclude && item.pattern == filepath.ToSlash(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unmatched returns the positive pathspec arguments that select none of names.
+func (p *Pathspec) Unmatched(names []string) []string {
+	if p == nil {
+		return nil
+	}
+
+	ret := make([]string, 0)
+	for _, item := range p.items {
+		if item.exclude || item.pattern == "." {
+			continue
+		}
+		found := false
+		for _, name := range names {
+			if item.match(filepath.ToSlash(name)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, item.original)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// ReadKeyring reads the OpenPGP keys in the file at path, which is either binary or holds
+// any number of armored key blocks, like several exports appended to each other.
+func ReadKeyring(path string) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read keyring %s", path)
+	}
+
+	begin := []byte("-----BEGIN PGP")
+	if !bytes.HasPrefix(bytes.TrimSpace(data), begin) {
+		keyring, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse keyring %s: %v", path, err)
+		}
+		return keyring, nil
+	}
+
+	keyring := make(openpgp.EntityList, 0)
+	for len(bytes.TrimSpace(data)) != 0 {
+		next := bytes.Index(data[1:], begin)
+		block := data
+		if next >= 0 {
+			block, data = data[:next+1], data[next+1:]
+		} else {
+			data = nil
+		}
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(block))
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse keyring %s: %v", path, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+	return keyring, nil
+}
+
+// PGPSigningKey finds the secret key in keyring that id names: a fingerprint or key id,
+// optionally prefixed with 0x, or part of a user id. An empty id picks the first key.
+func PGPSigningKey(keyring openpgp.EntityList, id string) (*openpgp.Entity, error) {
+	keyID := strings.ToUpper(strings.TrimPrefix(strings.TrimPrefix(strings.TrimSuffix(id, "!"), "0x"), "0X"))
+
+	for _, entity := range keyring {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		match := id == "" || strings.HasSuffix(PGPFingerprint(entity.PrimaryKey), keyID)
+		for _, subkey := range entity.Subkeys {
+			match = match || subkey.PrivateKey != nil && strings.HasSuffix(PGPFingerprint(subkey.PublicKey), keyID)
+		}
+		for name := range entity.Identities {
+			match = match || strings.Contains(strings.ToLower(name), strings.ToLower(id))
+		}
+		if !match {
+			continue
+		}
+
+		key, ok := entity.SigningKey(time.Now())
+		if !ok || key.PrivateKey == nil {
+			return nil, fmt.Errorf("secret key %s cannot sign", PGPFingerprint(entity.PrimaryKey))
+		}
+		if key.PrivateKey.Encrypted {
+			return nil, fmt.Errorf("secret key %s is protected by a passphrase", PGPFingerprint(entity.PrimaryKey))
+		}
+		return entity, nil
+	}
+
+	if id == "" {
+		return nil, fmt.Errorf("no secret key found")
+	}
+	return nil, fmt.Errorf("secret key not available: %s", id)
+}
+
+// PGPSign makes an armored detached signature of message.
+func PGPSign(signer *openpgp.Entity, message []byte) ([]byte, error) {
+	var armored bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&armored, signer, bytes.NewReader(message), nil); err != nil {
+		return nil, fmt.Errorf("cannot sign with key %s: %v", PGPFingerprint(signer.PrimaryKey), err)
+	}
+	if !bytes.HasSuffix(armored.Bytes(), []byte("\n")) {
+		armored.WriteByte('\n')
+	}
+	return armored.Bytes(), nil
+}
+
+// PGPVerify checks an armored detached signature of message against the keys of keyring.
+func PGPVerify(keyring openpgp.EntityList, armored, message []byte) (*packet.Signature, *openpgp.Entity, error) {
+	block, err := armor.Decode(bytes.NewReader(armored))
+	if err != nil || block.Type != "PGP SIGNATURE" {
+		return nil, nil, fmt.Errorf("malformed openpgp signature")
+	}
+
+	sig, signer, err := openpgp.VerifyDetachedSignature(keyring, bytes.NewReader(message), block.Body, nil)
+	switch {
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		return nil, nil, fmt.Errorf("Can't check signature: No public key")
+	case err != nil:
+		return nil, nil, fmt.Errorf("BAD signature: %v", err)
+	}
+	return sig, signer, nil
+}
+
+// PGPFingerprint is the fingerprint of key in upper case hex, as gpg prints it.
+func PGPFingerprint(key *packet.PublicKey) string {
+	return strings.ToUpper(hex.EncodeToString(key.Fingerprint))
+}
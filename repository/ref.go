@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
 )
 
 func RefResolve(repo *Repository, ref string) (*string, error) {
@@ -76,3 +77,57 @@ func RefList(repo *Repository, path *string) (map[string]any, error) {
 
 	return ret, nil
 }
+
+func RefUpdate(repo *Repository, ref, sha string) error {
+	path, err := repo.FileMk(strings.Split(ref, "/")...)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*path, []byte(sha+"\n"), 0644); err != nil {
+		return fmt.Errorf("cannot update ref %s", ref)
+	}
+	return nil
+}
+
+func RefDelete(repo *Repository, ref string) error {
+	path := repo.Path(ref)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot delete ref %s", ref)
+	}
+	return nil
+}
+
+func (r *Repository) SetHead(ref string) error {
+	content := ref
+	if strings.HasPrefix(ref, "refs/") {
+		content = "ref: " + ref
+	}
+	return RefUpdate(r, "HEAD", content)
+}
+
+func (r *Repository) UpdateHead(sha string) error {
+	branch, err := r.ActiveBranch()
+	if err != nil {
+		return err
+	}
+	if len(branch) != 0 {
+		return RefUpdate(r, "refs/heads/"+branch, sha)
+	}
+	return RefUpdate(r, "HEAD", sha)
+}
+
+// Head returns the commit HEAD points at, or an empty string on an unborn branch.
+func (r *Repository) Head() (string, error) {
+	headPath, err := r.File("HEAD")
+	if err != nil {
+		return "", err
+	}
+	head, err := RefResolve(r, *headPath)
+	if err != nil {
+		return "", err
+	}
+	if head == nil {
+		return "", nil
+	}
+	return *head, nil
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ReflogEntry struct {
+	Old      string
+	New      string
+	Identity string
+	Message  string
+}
+
+func (e ReflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s\t%s\n", e.Old, e.New, e.Identity, e.Message)
+}
+
+func ReflogRead(repo *Repository, ref string) ([]ReflogEntry, error) {
+	data, err := os.ReadFile(repo.Path("logs", ref))
+	if os.IsNotExist(err) {
+		return []ReflogEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read reflog for %s", ref)
+	}
+
+	ret := make([]ReflogEntry, 0)
+	for _, line := range strings.Split(string(data), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		head, message, _ := strings.Cut(line, "\t")
+		fields := strings.SplitN(head, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed reflog entry for %s: %s", ref, line)
+		}
+		ret = append(ret, ReflogEntry{fields[0], fields[1], fields[2], message})
+	}
+
+	return ret, nil
+}
+
+func ReflogWrite(repo *Repository, ref string, entries []ReflogEntry) error {
+	path := repo.Path("logs", ref)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove reflog for %s", ref)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create reflog directory for %s", ref)
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e.String())
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("cannot write reflog for %s", ref)
+	}
+	return nil
+}
+
+func ReflogAppend(repo *Repository, ref string, entry ReflogEntry) error {
+	path := repo.Path("logs", ref)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("cannot create reflog directory for %s", ref)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open reflog for %s", ref)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(entry.String()); err != nil {
+		return fmt.Errorf("cannot write reflog for %s", ref)
+	}
+	return nil
+}
+
+// ReflogNth returns the nth most recent entry of a reflog, as in ref@{n}.
+func ReflogNth(repo *Repository, ref string, n int) (*ReflogEntry, error) {
+	entries, err := ReflogRead(repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= len(entries) {
+		return nil, fmt.Errorf("log for '%s' only has %d entries", ref, len(entries))
+	}
+	return &entries[len(entries)-1-n], nil
+}
@@ -3,16 +3,17 @@ package repository
 import (
 	"bufio"
 	"bytes"
-	"cmp"
+	"crypto/sha1"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"maps"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -131,6 +132,9 @@ func (r *Repository) FileMk(path ...string) (*string, error) {
 }
 
 var hashRe *regexp.Regexp = regexp.MustCompile("^[0-9A-Fa-f]{4,40}$")
+var ancestryRe *regexp.Regexp = regexp.MustCompile(`^(.+?)((?:[~^][0-9]*)+)$`)
+var reflogRe *regexp.Regexp = regexp.MustCompile(`^(.+)@\{([0-9]+)\}$`)
+var ancestryStepRe *regexp.Regexp = regexp.MustCompile(`[~^][0-9]*`)
 
 func (r *Repository) Resolve(name string) ([]string, error) {
 	name = strings.TrimSpace(name)
@@ -138,8 +142,36 @@ func (r *Repository) Resolve(name string) ([]string, error) {
 		return nil, fmt.Errorf("name must be supplied")
 	}
 
-	if name == "HEAD" {
-		headPath, err := r.File("HEAD")
+	if name == "@" {
+		name = "HEAD"
+	}
+
+	if m := reflogRe.FindStringSubmatch(name); m != nil {
+		ref, ok := r.ResolveRef(m[1])
+		if !ok {
+			return []string{}, nil
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reflog index %s", name)
+		}
+		entry, err := ReflogNth(r, ref, n)
+		if err != nil {
+			return nil, err
+		}
+		return []string{entry.New}, nil
+	}
+
+	if m := ancestryRe.FindStringSubmatch(name); m != nil {
+		sha, err := r.resolveAncestry(m[1], m[2])
+		if err != nil {
+			return nil, err
+		}
+		return []string{sha}, nil
+	}
+
+	if name == "HEAD" || name == "ORIG_HEAD" {
+		headPath, err := r.File(name)
 		if err != nil {
 			return nil, err
 		}
@@ -147,6 +179,9 @@ func (r *Repository) Resolve(name string) ([]string, error) {
 		if err != nil {
 			return nil, err
 		}
+		if head == nil {
+			return []string{}, nil
+		}
 		return []string{*head}, nil
 	}
 
@@ -174,43 +209,101 @@ func (r *Repository) Resolve(name string) ([]string, error) {
 		}
 	}
 
-	tagPath, err := r.File("refs/tags/" + name)
-	if err != nil {
-		return nil, err
+	for _, prefix := range []string{"", "refs/", "refs/tags/", "refs/heads/", "refs/remotes/"} {
+		if prefix == "" && !strings.HasPrefix(name, "refs/") {
+			continue
+		}
+
+		refPath, err := r.File(prefix + name)
+		if err != nil {
+			return nil, err
+		}
+		if refPath == nil {
+			continue
+		}
+		sha, err := RefResolve(r, *refPath)
+		if err != nil {
+			return nil, err
+		}
+		if sha != nil && !slices.Contains(candidates, *sha) {
+			candidates = append(candidates, *sha)
+		}
 	}
-	asTag, err := RefResolve(r, *tagPath)
-	if err != nil {
-		return nil, err
+
+	return candidates, nil
+}
+
+// ResolveRef expands a short ref name such as "main" or "stash" to the full name of an
+// existing ref, e.g. "refs/heads/main".
+func (r *Repository) ResolveRef(name string) (string, bool) {
+	if name == "HEAD" || name == "ORIG_HEAD" {
+		return name, true
 	}
-	if asTag != nil {
-		candidates = append(candidates, *asTag)
+	for _, prefix := range []string{"", "refs/", "refs/tags/", "refs/heads/", "refs/remotes/"} {
+		if prefix == "" && !strings.HasPrefix(name, "refs/") {
+			continue
+		}
+		if stat, err := os.Stat(r.Path(prefix + name)); err == nil && !stat.IsDir() {
+			return prefix + name, true
+		}
 	}
+	return "", false
+}
 
-	branchPath, err := r.File("refs/heads/" + name)
-	if err != nil {
-		return nil, err
-	}
-	asBranch, err := RefResolve(r, *branchPath)
+func (r *Repository) resolveAncestry(base, steps string) (string, error) {
+	sha, err := ObjectFind(r, base, "commit")
 	if err != nil {
-		return nil, err
-	}
-	if asBranch != nil {
-		candidates = append(candidates, *asBranch)
+		return "", err
 	}
 
-	remoteBranchPath, err := r.File("refs/remotes/" + name)
-	if err != nil {
-		return nil, err
+	for _, step := range ancestryStepRe.FindAllString(steps, -1) {
+		n := 1
+		if len(step) > 1 {
+			n, err = strconv.Atoi(step[1:])
+			if err != nil {
+				return "", fmt.Errorf("invalid revision %s%s", base, steps)
+			}
+		}
+
+		if step[0] == '^' && n == 0 {
+			continue
+		}
+
+		count, nth := n, 1
+		if step[0] == '^' {
+			count, nth = 1, n
+		}
+
+		for range count {
+			commit, err := ReadCommit(r, sha)
+			if err != nil {
+				return "", err
+			}
+			parents := commit.Parents()
+			if len(parents) < nth {
+				return "", fmt.Errorf("revision %s%s does not exist", base, steps)
+			}
+			sha = parents[nth-1]
+		}
 	}
-	asRemoteBranch, err := RefResolve(r, *remoteBranchPath)
-	if err != nil {
-		return nil, err
+
+	return sha, nil
+}
+
+// indexVersion is the format new indexes are written in: that of the current index if
+// there is one, otherwise index.version.
+func (r *Repository) indexVersion() int {
+	if file, err := os.Open(r.Path("index")); err == nil {
+		defer file.Close()
+		var header IndexHeader
+		if binary.Read(file, binary.BigEndian, &header) == nil && header.Version >= 2 && header.Version <= 4 {
+			return int(header.Version)
+		}
 	}
-	if asRemoteBranch != nil {
-		candidates = append(candidates, *asRemoteBranch)
+	if version := r.Conf.Section("index").Key("version").MustInt(2); version >= 2 && version <= 4 {
+		return version
 	}
-
-	return candidates, nil
+	return 2
 }
 
 func (r *Repository) ReadIndex() (*Index, error) {
@@ -219,8 +312,11 @@ func (r *Repository) ReadIndex() (*Index, error) {
 		return nil, fmt.Errorf("could not find index path")
 	}
 
-	if _, err := os.Stat(*indexFile); err != nil {
-		return nil, nil
+	indexStat, err := os.Stat(*indexFile)
+	if err != nil {
+		index := NewIndexV2(nil)
+		index.Version = r.indexVersion()
+		return &index, nil
 	}
 
 	raw, err := os.ReadFile(*indexFile)
@@ -228,6 +324,15 @@ func (r *Repository) ReadIndex() (*Index, error) {
 		return nil, fmt.Errorf("error reading index file")
 	}
 
+	if len(raw) < 12+sha1.Size {
+		return nil, fmt.Errorf("index file smaller than expected")
+	}
+	body, trailer := raw[:len(raw)-sha1.Size], raw[len(raw)-sha1.Size:]
+	// index.skipHash writes a null trailer instead of the checksum
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], trailer) && !bytes.Equal(trailer, make([]byte, sha1.Size)) {
+		return nil, fmt.Errorf("index file corrupt: bad index file sha1 signature")
+	}
+
 	rawHeader := raw[:12]
 	var header IndexHeader
 	if err := binary.Read(bytes.NewBuffer(rawHeader), binary.BigEndian, &header); err != nil {
@@ -236,14 +341,19 @@ func (r *Repository) ReadIndex() (*Index, error) {
 	if !bytes.Equal(header.Signature[:], []byte("DIRC")) {
 		return nil, fmt.Errorf("incorrect header signature")
 	}
-	if header.Version != 2 {
-		return nil, fmt.Errorf("wyog only supports index file version 2")
+	if header.Version < 2 || header.Version > 4 {
+		return nil, fmt.Errorf("bad index version %d", header.Version)
 	}
 
-	content := raw[12:]
+	content := body[12:]
+	corrupt := fmt.Errorf("index file corrupt")
 	idx := 0
+	prevName := ""
 	entries := make([]IndexEntry, 0)
 	for range header.Count {
+		if len(content)-idx < 62 {
+			return nil, corrupt
+		}
 		var entry IndexBinaryEntry
 		if err := binary.Read(bytes.NewBuffer(content[idx:idx+62]), binary.BigEndian, &entry); err != nil {
 			return nil, fmt.Errorf("cannot read index entry")
@@ -266,79 +376,189 @@ func (r *Repository) ReadIndex() (*Index, error) {
 
 		assumeValid := (entry.Flags & 0b1000000000000000) != 0
 		extended := (entry.Flags & 0b0100000000000000) != 0
-		if extended {
-			return nil, fmt.Errorf("version 2 does not support extended")
-		}
-		stage := entry.Flags & 0b0011000000000000
+		stage := (entry.Flags & 0b0011000000000000) >> 12
 
 		nameLength := entry.Flags & 0b0000111111111111
 
 		idx += 62
 
-		var rawName []byte
-		if nameLength < 0xFFF {
-			if content[idx+int(nameLength)] != 0x00 {
-				return nil, fmt.Errorf("index entry name is incorrect format")
+		var skipWorktree, intentToAdd bool
+		if extended {
+			if header.Version < 3 {
+				return nil, fmt.Errorf("version 2 does not support extended")
 			}
+			if len(content)-idx < 2 {
+				return nil, corrupt
+			}
+			extendedFlags := binary.BigEndian.Uint16(content[idx : idx+2])
+			if extendedFlags&^(indexSkipWorktree|indexIntentToAdd) != 0 {
+				return nil, fmt.Errorf("unknown index entry format 0x%04x", extendedFlags)
+			}
+			skipWorktree = extendedFlags&indexSkipWorktree != 0
+			intentToAdd = extendedFlags&indexIntentToAdd != 0
+			idx += 2
+		}
 
-			rawName = content[idx : idx+int(nameLength)]
-			idx += int(nameLength) + 1
+		var name string
+		if header.Version == 4 {
+			// the name replaces the last `strip` bytes of the previous one
+			strip, n := indexVarint(content[idx:])
+			if n == 0 || strip < 0 || strip > len(prevName) {
+				return nil, fmt.Errorf("index file corrupt: malformed name field")
+			}
+			idx += n
+			end := bytes.IndexByte(content[idx:], 0x00)
+			if end < 0 {
+				return nil, fmt.Errorf("index entry name is incorrect format")
+			}
+			name = prevName[:len(prevName)-strip] + string(content[idx:idx+end])
+			idx += end + 1
 		} else {
-			nullIdx := bytes.Index(content[idx+0xFFF:], []byte{'\x00'})
-			rawName = content[idx : idx+nullIdx]
-			idx += nullIdx + 1
-		}
+			var rawName []byte
+			if nameLength < 0xFFF {
+				if len(content)-idx <= int(nameLength) {
+					return nil, corrupt
+				}
+				if content[idx+int(nameLength)] != 0x00 {
+					return nil, fmt.Errorf("index entry name is incorrect format")
+				}
+
+				rawName = content[idx : idx+int(nameLength)]
+				idx += int(nameLength) + 1
+			} else {
+				if len(content)-idx < 0xFFF {
+					return nil, corrupt
+				}
+				end := bytes.IndexByte(content[idx+0xFFF:], 0x00)
+				if end < 0 {
+					return nil, corrupt
+				}
+				nullIdx := 0xFFF + end
+				rawName = content[idx : idx+nullIdx]
+				idx += nullIdx + 1
+			}
 
-		name := string(rawName)
-		idx = int(8 * math.Ceil(float64(idx)/8))
+			name = string(rawName)
+			idx = int(8 * math.Ceil(float64(idx)/8))
+			if idx > len(content) {
+				return nil, corrupt
+			}
+		}
+		prevName = name
 
 		entries = append(entries, IndexEntry{
-			Ctime:       ctime,
-			Mtime:       mtime,
-			Dev:         int(entry.Dev),
-			Ino:         int(entry.Ino),
-			ModeType:    int(modeType),
-			ModePerms:   int(modePerms),
-			Uid:         int(entry.Uid),
-			Gid:         int(entry.Gid),
-			Fsize:       int(entry.Fsize),
-			Sha:         sha,
-			AssumeValid: assumeValid,
-			Stage:       int(stage),
-			Name:        name,
+			Ctime:        ctime,
+			Mtime:        mtime,
+			Dev:          int(entry.Dev),
+			Ino:          int(entry.Ino),
+			ModeType:     int(modeType),
+			ModePerms:    int(modePerms),
+			Uid:          int(entry.Uid),
+			Gid:          int(entry.Gid),
+			Fsize:        int(entry.Fsize),
+			Sha:          sha,
+			AssumeValid:  assumeValid,
+			SkipWorktree: skipWorktree,
+			IntentToAdd:  intentToAdd,
+			Stage:        int(stage),
+			Name:         name,
 		})
 	}
 
 	index := NewIndexV2(entries)
+	index.Version = int(header.Version)
+	index.timestamp = indexStat.ModTime()
+
+	for idx < len(content) {
+		if idx+8 > len(content) {
+			return nil, fmt.Errorf("index file corrupt: truncated extension header")
+		}
+		signature := string(content[idx : idx+4])
+		size := int(binary.BigEndian.Uint32(content[idx+4 : idx+8]))
+		idx += 8
+		if idx+size > len(content) {
+			return nil, fmt.Errorf("index file corrupt: extension %s is truncated", signature)
+		}
+		// only extensions starting with an upper case letter may be ignored
+		if signature[0] < 'A' || signature[0] > 'Z' {
+			return nil, fmt.Errorf("index uses %s extension, which we do not understand", signature)
+		}
+		data := content[idx : idx+size]
+		idx += size
+		if signature == "TREE" {
+			tree, err := parseCacheTree(data)
+			if err != nil {
+				return nil, err
+			}
+			index.Tree = tree
+			continue
+		}
+		index.Extensions = append(index.Extensions, IndexExtension{signature, slices.Clone(data)})
+	}
+
 	return &index, nil
 }
 
-func (r *Repository) WriteIndex(index *Index) error {
-	indexPath, err := r.File("index")
+// LockIndex takes the index lock and then reads the index, so that no other process can
+// change it before it is written back with WriteIndex. Callers that end up not writing
+// the index release the lock with Rollback.
+func (r *Repository) LockIndex() (*LockFile, *Index, error) {
+	lock, err := r.Lock("index")
+	if err != nil {
+		return nil, nil, err
+	}
+	index, err := r.ReadIndex()
 	if err != nil {
-		return err
+		lock.Rollback()
+		return nil, nil, err
 	}
+	return lock, index, nil
+}
 
-	file, err := os.OpenFile(*indexPath, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+// LockIndexIfAble is LockIndex for commands that only write the index back as an
+// optimisation. When the lock can't be taken the index is read without it, and the
+// returned lock is nil.
+func (r *Repository) LockIndexIfAble() (*LockFile, *Index, error) {
+	lock, err := r.Lock("index")
+	if err != nil {
+		index, err := r.ReadIndex()
+		return nil, index, err
+	}
+	index, err := r.ReadIndex()
 	if err != nil {
-		return err
+		lock.Rollback()
+		return nil, nil, err
 	}
-	defer file.Close()
-	w := bufio.NewWriter(file)
-	defer w.Flush()
+	return lock, index, nil
+}
+
+// WriteIndex writes the index through a lock taken with LockIndex and commits it.
+func (r *Repository) WriteIndex(lock *LockFile, index *Index) error {
+	buf := bufio.NewWriter(lock)
+	checksum := sha1.New()
+	w := io.MultiWriter(buf, checksum)
 	writeErr := fmt.Errorf("error writing to index")
 
 	if err := binary.Write(w, binary.BigEndian, []byte("DIRC")); err != nil {
 		return writeErr
 	}
-	if err := binary.Write(w, binary.BigEndian, int32(index.Version)); err != nil {
+	version := index.Version
+	if version != 4 {
+		// like git, only use version 3 when some entry needs the extended flags
+		version = 2
+		if slices.ContainsFunc(index.Entries, IndexEntry.extended) {
+			version = 3
+		}
+	}
+
+	if err := binary.Write(w, binary.BigEndian, int32(version)); err != nil {
 		return writeErr
 	}
 	if err := binary.Write(w, binary.BigEndian, int32(len(index.Entries))); err != nil {
 		return writeErr
 	}
 
-	idx := 0
+	prevName := ""
 	for _, entry := range index.Entries {
 		mode := entry.ModeType<<12 | entry.ModePerms
 		sha, err := hex.DecodeString(entry.Sha)
@@ -349,8 +569,21 @@ func (r *Repository) WriteIndex(index *Index) error {
 		if entry.AssumeValid {
 			flagAssumeValid = 0x1 << 15
 		}
+		var flagExtended uint16
+		if entry.extended() {
+			flagExtended = 0x1 << 14
+		}
 		nameBytes := []byte(entry.Name)
-		nameLen := min(len(nameBytes), 0xFF)
+		nameLen := min(len(nameBytes), 0xFFF)
+
+		// a file changed since it was staged but within the old index's timestamp
+		// would look clean once this newer index exists, so make its size mismatch
+		fsize := entry.Fsize
+		if entry.Stage == 0 && !entry.extended() && index.racy(entry) {
+			if changed, err := r.EntryChanged(index, entry); err == nil && changed {
+				fsize = 0
+			}
+		}
 
 		binEntry := IndexBinaryEntry{
 			CtimeSec:  uint32(entry.Ctime.Unix()),
@@ -363,115 +596,101 @@ func (r *Repository) WriteIndex(index *Index) error {
 			Mode:      uint16(mode),
 			Uid:       uint32(entry.Uid),
 			Gid:       uint32(entry.Gid),
-			Fsize:     uint32(entry.Fsize),
+			Fsize:     uint32(fsize),
 			Sha:       [20]byte(sha),
-			Flags:     flagAssumeValid | uint16(entry.Stage) | uint16(nameLen),
+			Flags:     flagAssumeValid | flagExtended | uint16(entry.Stage)<<12 | uint16(nameLen),
 		}
 
+		size := 62
 		if err := binary.Write(w, binary.BigEndian, binEntry); err != nil {
 			return writeErr
 		}
-		if err := binary.Write(w, binary.BigEndian, nameBytes); err != nil {
-			return writeErr
-		}
-		if err := binary.Write(w, binary.BigEndian, byte('\x00')); err != nil {
-			return writeErr
+		if entry.extended() {
+			var extendedFlags uint16
+			if entry.SkipWorktree {
+				extendedFlags |= indexSkipWorktree
+			}
+			if entry.IntentToAdd {
+				extendedFlags |= indexIntentToAdd
+			}
+			if err := binary.Write(w, binary.BigEndian, extendedFlags); err != nil {
+				return writeErr
+			}
+			size += 2
 		}
 
-		idx += 62 + len(nameBytes) + 1
-		if idx%8 != 0 {
-			pad := 8 - (idx % 8)
-			for range pad {
-				if err := binary.Write(w, binary.BigEndian, byte('\x00')); err != nil {
-					return writeErr
-				}
+		if version == 4 {
+			common := 0
+			for common < min(len(prevName), len(entry.Name)) && prevName[common] == entry.Name[common] {
+				common++
 			}
-
-			idx += pad
+			if _, err := w.Write(appendIndexVarint(nil, len(prevName)-common)); err != nil {
+				return writeErr
+			}
+			if _, err := w.Write(append([]byte(entry.Name[common:]), 0x00)); err != nil {
+				return writeErr
+			}
+			prevName = entry.Name
+			continue
 		}
-	}
-	return nil
-}
-
-type dirEntry struct {
-	basename, sha string
-}
-
-func (r *Repository) TreeFromIndex(index *Index) (string, error) {
-	contents := make(map[string][]any)
-	contents[""] = make([]any, 0)
 
-	for _, entry := range index.Entries {
-		dirName := filepath.Dir(entry.Name)
-		if dirName == "." {
-			dirName = ""
+		if err := binary.Write(w, binary.BigEndian, nameBytes); err != nil {
+			return writeErr
 		}
+		size += len(nameBytes)
 
-		key := dirName
-		for key != "" {
-			if _, ok := contents[key]; !ok {
-				contents[key] = make([]any, 0)
-			}
-			key = filepath.Dir(key)
-			if key == "." {
-				key = ""
-			}
+		// names are NUL terminated and padded to a multiple of eight bytes
+		pad := 8 - size%8
+		if _, err := w.Write(make([]byte, pad)); err != nil {
+			return writeErr
 		}
-
-		contents[dirName] = append(contents[dirName], entry)
 	}
 
-	sortedPath := slices.SortedFunc(maps.Keys(contents), func(a, b string) int {
-		return cmp.Compare(len(b), len(a))
-	})
-
-	sha := ""
-
-	for _, path := range sortedPath {
-		tree := Tree{}
-
-		for _, entry := range contents[path] {
-
-			var leaf TreeLeaf
-			switch entry := entry.(type) {
-			case IndexEntry:
-				leafMode := fmt.Sprintf("%02o%04o", entry.ModeType, entry.ModePerms)
-				leaf = TreeLeaf{
-					Mode: []byte(leafMode),
-					Path: filepath.Base(entry.Name),
-					Sha:  entry.Sha,
-				}
-			case dirEntry:
-				leaf = TreeLeaf{
-					Mode: []byte("040000"),
-					Path: entry.basename,
-					Sha:  entry.sha,
-				}
-			default:
-				return "", fmt.Errorf("invalid value type")
-			}
-
-			tree.Items = append(tree.Items, leaf)
+	extensions := index.Extensions
+	if index.Tree != nil {
+		extensions = slices.Concat([]IndexExtension{{"TREE", index.Tree.encode(nil)}}, extensions)
+	}
+	for _, ext := range extensions {
+		if staleExtensions[ext.Signature] {
+			continue
 		}
-
-		var err error
-		sha, err = Write(&tree, r)
-		if err != nil {
-			return "", err
+		if err := binary.Write(w, binary.BigEndian, []byte(ext.Signature)); err != nil {
+			return writeErr
 		}
-
-		parent := filepath.Dir(path)
-		if parent == "." {
-			parent = ""
+		if err := binary.Write(w, binary.BigEndian, uint32(len(ext.Data))); err != nil {
+			return writeErr
 		}
-		base := filepath.Base(path)
-		if base == "." {
-			continue
+		if _, err := w.Write(ext.Data); err != nil {
+			return writeErr
 		}
-		contents[parent] = append(contents[parent], dirEntry{base, sha})
 	}
 
-	return sha, nil
+	if _, err := buf.Write(checksum.Sum(nil)); err != nil {
+		return writeErr
+	}
+	if err := buf.Flush(); err != nil {
+		return writeErr
+	}
+	return lock.Commit()
+}
+
+// TreeFromIndex writes the tree objects for the index and returns the root tree. Trees
+// already recorded in the index's cache are reused, and the cache is updated so that
+// writing the index back keeps them for next time.
+func (r *Repository) TreeFromIndex(index *Index) (string, error) {
+	if unmerged := index.Unmerged(); len(unmerged) != 0 {
+		return "", fmt.Errorf("cannot write tree, unmerged paths: %s", strings.Join(unmerged, ", "))
+	}
+
+	index.Sort()
+	if index.Tree == nil {
+		index.Tree = &CacheTree{Entries: -1}
+	}
+	if _, err := r.updateCacheTree(index.Tree, index.Entries, ""); err != nil {
+		return "", err
+	}
+
+	return index.Tree.Sha, nil
 }
 
 func (r *Repository) ReadGitignore() (Ignores, error) {
@@ -535,58 +754,45 @@ func (r *Repository) ReadGitignore() (Ignores, error) {
 	return ret, nil
 }
 
-func (repo *Repository) Rm(del, skipMissing bool, paths ...string) error {
-	index, err := repo.ReadIndex()
+func (repo *Repository) Rm(del, skipMissing bool, spec *Pathspec) ([]string, error) {
+	lock, index, err := repo.LockIndex()
 	if err != nil {
-		return err
-	}
-
-	worktree := fmt.Sprintf("%s%c", repo.Worktree, filepath.Separator)
-
-	absPaths := make(map[string]bool)
-
-	for _, path := range paths {
-		absPath, err := filepath.Abs(path)
-		if err != nil {
-			return fmt.Errorf("cannot convert %s to an absolute path", path)
-		}
-		if strings.HasPrefix(absPath, worktree) {
-			absPaths[absPath] = true
-		} else {
-			return fmt.Errorf("cannot remove paths outside of worktree: %s", path)
-		}
+		return nil, err
 	}
+	defer lock.Rollback()
 
 	keptEntries := make([]IndexEntry, 0)
 	remove := make([]string, 0)
 
 	for _, e := range index.Entries {
-		fullPath := filepath.Join(repo.Worktree, e.Name)
-
-		if absPaths[fullPath] {
-			remove = append(remove, fullPath)
-			delete(absPaths, fullPath)
+		if spec.Match(e.Name) {
+			if len(remove) == 0 || remove[len(remove)-1] != e.Name {
+				remove = append(remove, e.Name)
+			}
+			index.Invalidate(e.Name)
 		} else {
 			keptEntries = append(keptEntries, e)
 		}
 	}
 
-	if len(absPaths) > 0 && !skipMissing {
-		return fmt.Errorf("cannot remove paths not in the index: %v", absPaths)
+	if unmatched := spec.Unmatched(remove); len(unmatched) > 0 && !skipMissing {
+		return nil, fmt.Errorf("pathspec '%s' did not match any files", unmatched[0])
 	}
 
 	if del {
 		for _, path := range remove {
-			os.Remove(path)
+			if err := repo.RemoveWorktreeFile(path); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	index.Entries = keptEntries
-	if err := repo.WriteIndex(index); err != nil {
-		return err
+	if err := repo.WriteIndex(lock, index); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return remove, nil
 }
 
 func (r *Repository) ActiveBranch() (string, error) {
@@ -633,3 +839,19 @@ func FindRequire(path string) (string, error) {
 
 	return *p, nil
 }
+
+// RelPath converts a path given relative to the current directory into a path
+// relative to the worktree root.
+func (r *Repository) RelPath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot convert %s to an absolute path", path)
+	}
+	if absPath == r.Worktree {
+		return ".", nil
+	}
+	if !strings.HasPrefix(absPath, r.Worktree+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside repository at %s", path, r.Worktree)
+	}
+	return filepath.Rel(r.Worktree, absPath)
+}
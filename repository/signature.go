@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const PGPSignatureBegin = "-----BEGIN PGP SIGNATURE-----"
+
+// the lines that start the signature at the end of a tag message
+var signatureBegins = []string{PGPSignatureBegin, "-----BEGIN PGP MESSAGE-----", SSHSignatureBegin}
+
+// SignaturePayload splits a commit into the data that was signed, the commit without
+// its gpgsig header, and the signature. The signature is empty for an unsigned commit.
+func (c *Commit) SignaturePayload() ([]byte, []byte) {
+	sig, ok := c.Kvlm.Get("gpgsig")
+	if !ok || len(sig) == 0 {
+		return c.Serialize(), nil
+	}
+
+	return c.Kvlm.Without("gpgsig").Serialize(), []byte(sig[0] + "\n")
+}
+
+// SetSignature records sig, made over the serialized commit, in its gpgsig header.
+func (c *Commit) SetSignature(sig []byte) {
+	c.Kvlm.Set("gpgsig", []string{strings.TrimSuffix(string(sig), "\n")})
+}
+
+// SignaturePayload splits a tag into the data that was signed and the signature.
+func (t *Tag) SignaturePayload() ([]byte, []byte) {
+	message, sig := t.SplitSignature()
+	payload := KvlmData{Headers: t.Kvlm.Headers, Message: message}
+	return payload.Serialize(), sig
+}
+
+// SplitSignature splits the message of a tag from the signature at its end, which starts
+// at the last line that begins a signature of any type.
+func (t *Tag) SplitSignature() ([]byte, []byte) {
+	message := t.Kvlm.Message
+	start := len(message)
+	for i := 0; i < len(message); {
+		if slices.ContainsFunc(signatureBegins, func(begin string) bool {
+			return bytes.HasPrefix(message[i:], []byte(begin))
+		}) {
+			start = i
+		}
+		eol := bytes.IndexByte(message[i:], '\n')
+		if eol < 0 {
+			break
+		}
+		i += eol + 1
+	}
+	return message[:start], message[start:]
+}
+
+// SetSignature appends sig, made over the serialized tag, to its message in place of
+// any earlier signature.
+func (t *Tag) SetSignature(sig []byte) {
+	message, _ := t.SplitSignature()
+	t.Kvlm.Message = append(message[:len(message):len(message)], sig...)
+}
+
+// MergeTags returns the tags that a merge commit records in its mergetag headers.
+func (c *Commit) MergeTags() ([]*Tag, error) {
+	values, _ := c.Kvlm.Get("mergetag")
+	tags := make([]*Tag, 0, len(values))
+	for _, value := range values {
+		tag, err := NewTag([]byte(value + "\n"))
+		if err != nil {
+			return nil, fmt.Errorf("malformed mergetag: %v", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
@@ -0,0 +1,210 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SSH signatures follow the sshsig format of OpenSSH, see PROTOCOL.sshsig.
+
+const (
+	SSHSignatureBegin = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureEnd   = "-----END SSH SIGNATURE-----"
+	sshSigMagic       = "SSHSIG"
+	sshSigVersion     = 1
+)
+
+type sshSigBlob struct {
+	PublicKey     []byte
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Signature     []byte
+}
+
+type sshSignedData struct {
+	Namespace     string
+	Reserved      string
+	HashAlgorithm string
+	Hash          []byte
+}
+
+func sshSigHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported ssh signature hash algorithm %s", algorithm)
+	}
+}
+
+func sshSignedMessage(namespace, algorithm string, message []byte) ([]byte, error) {
+	h, err := sshSigHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	h.Write(message)
+	return append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{
+		Namespace:     namespace,
+		HashAlgorithm: algorithm,
+		Hash:          h.Sum(nil),
+	})...), nil
+}
+
+// SSHSign signs message for namespace, returning the armored signature.
+func SSHSign(signer ssh.Signer, namespace string, message []byte) ([]byte, error) {
+	data, err := sshSignedMessage(namespace, "sha512", message)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig *ssh.Signature
+	// sshsig needs rsa-sha2-512 rather than the SHA-1 based ssh-rsa
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign with %s key: %v", signer.PublicKey().Type(), err)
+	}
+
+	blob := binary.BigEndian.AppendUint32([]byte(sshSigMagic), sshSigVersion)
+	blob = append(blob, ssh.Marshal(sshSigBlob{
+		PublicKey:     signer.PublicKey().Marshal(),
+		Namespace:     namespace,
+		HashAlgorithm: "sha512",
+		Signature:     ssh.Marshal(sig),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var armored bytes.Buffer
+	armored.WriteString(SSHSignatureBegin + "\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n")
+	armored.WriteString(sshSignatureEnd + "\n")
+	return armored.Bytes(), nil
+}
+
+// SSHVerify checks an armored signature of message for namespace, returning the key
+// that made it.
+func SSHVerify(armored []byte, namespace string, message []byte) (ssh.PublicKey, error) {
+	text := strings.TrimSpace(string(armored))
+	body, ok := strings.CutPrefix(text, SSHSignatureBegin)
+	if !ok {
+		return nil, fmt.Errorf("not an ssh signature")
+	}
+	body, ok = strings.CutSuffix(body, sshSignatureEnd)
+	if !ok {
+		return nil, fmt.Errorf("malformed ssh signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return nil, fmt.Errorf("malformed ssh signature")
+	}
+
+	rest, ok := bytes.CutPrefix(raw, []byte(sshSigMagic))
+	if !ok || len(rest) < 4 {
+		return nil, fmt.Errorf("malformed ssh signature")
+	}
+	if version := binary.BigEndian.Uint32(rest); version != sshSigVersion {
+		return nil, fmt.Errorf("unsupported ssh signature version %d", version)
+	}
+	var blob sshSigBlob
+	if err := ssh.Unmarshal(rest[4:], &blob); err != nil {
+		return nil, fmt.Errorf("malformed ssh signature")
+	}
+	if blob.Namespace != namespace {
+		return nil, fmt.Errorf("signature is for namespace '%s', not '%s'", blob.Namespace, namespace)
+	}
+
+	key, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the key of the signature")
+	}
+	var sig ssh.Signature
+	if err := ssh.Unmarshal(blob.Signature, &sig); err != nil {
+		return nil, fmt.Errorf("malformed ssh signature")
+	}
+	if key.Type() == ssh.KeyAlgoRSA && sig.Format == ssh.KeyAlgoRSA {
+		return nil, fmt.Errorf("ssh-rsa signatures are not accepted, rsa-sha2-256 or rsa-sha2-512 are")
+	}
+
+	data, err := sshSignedMessage(namespace, blob.HashAlgorithm, message)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Verify(data, &sig); err != nil {
+		return nil, fmt.Errorf("incorrect signature")
+	}
+	return key, nil
+}
+
+// AllowedSigners returns the principals that the allowed signers file at path lets
+// sign in namespace with key. The file has the format of ssh-keygen(1), lines of
+//
+//	principal[,principal...] [options] keytype base64-key [comment]
+func AllowedSigners(path string, key ssh.PublicKey, namespace string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read allowed signers file %s", path)
+	}
+	defer file.Close()
+
+	principals := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names, rest, _ := strings.Cut(line, " ")
+		allowed, _, options, _, err := ssh.ParseAuthorizedKey([]byte(rest))
+		if err != nil {
+			return nil, fmt.Errorf("malformed line in allowed signers file %s: %s", path, line)
+		}
+		if !bytes.Equal(allowed.Marshal(), key.Marshal()) || !allowedNamespace(options, namespace) {
+			continue
+		}
+		for _, name := range strings.Split(strings.Trim(names, `"`), ",") {
+			if !slices.Contains(principals, name) {
+				principals = append(principals, name)
+			}
+		}
+	}
+	return principals, scanner.Err()
+}
+
+func allowedNamespace(options []string, namespace string) bool {
+	for _, option := range options {
+		value, ok := strings.CutPrefix(option, "namespaces=")
+		if !ok {
+			continue
+		}
+		for _, pattern := range strings.Split(strings.Trim(value, `"`), ",") {
+			if ok, _ := path.Match(pattern, namespace); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,374 @@
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Trailer is a "token: value" line in the last paragraph of a message, with any
+// continuation lines kept in the value. A line of the trailer block that isn't a trailer
+// has an empty Token and the whole line as its Value.
+type Trailer struct {
+	Token string
+	Value string
+}
+
+// ConfiguredTrailer is a trailer set up with trailer.<Name>.key and trailer.<Name>.ifexists.
+type ConfiguredTrailer struct {
+	Name     string
+	Key      string
+	IfExists string
+}
+
+// TrailerOptions control how trailers are recognized and added.
+type TrailerOptions struct {
+	// Separators are the characters that can end a token, ":" if empty.
+	Separators string
+	// IfExists is what adding a trailer whose token is already there does by default.
+	IfExists   string
+	Configured []ConfiguredTrailer
+}
+
+var trailerActions = []string{"addIfDifferentNeighbor", "addIfDifferent", "add", "replace", "doNothing"}
+
+// CheckIfExists checks that action is one of the ways to add a trailer that already exists.
+func CheckIfExists(action string) error {
+	for _, a := range trailerActions {
+		if strings.EqualFold(a, action) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown value for if-exists: %s", action)
+}
+
+// lines that count towards a trailer block even when most of it is free text
+var gitGeneratedPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+const scissorsLine = "# ------------------------ >8 ------------------------\n"
+
+func (o TrailerOptions) separators() string {
+	return cmp.Or(o.Separators, ":")
+}
+
+// configured finds the configured trailer that token abbreviates.
+func (o TrailerOptions) configured(token string) (ConfiguredTrailer, bool) {
+	abbreviates := func(name string) bool {
+		return name != "" && len(token) <= len(name) && strings.EqualFold(token, name[:len(token)])
+	}
+	for _, c := range o.Configured {
+		if abbreviates(c.Name) || abbreviates(c.Key) {
+			return c, true
+		}
+	}
+	return ConfiguredTrailer{}, false
+}
+
+// trailer makes a trailer of a line split at a separator, writing the token as its
+// configured key.
+func (o TrailerOptions) trailer(token, value string) Trailer {
+	token = strings.TrimSpace(token)
+	if c, ok := o.configured(token); ok && c.Key != "" {
+		token = c.Key
+	}
+	return Trailer{Token: token, Value: strings.TrimSpace(value)}
+}
+
+// findSeparator returns where the token of a trailer line ends, or -1 if the line isn't a
+// trailer. Tokens are letters, digits and dashes, optionally followed by blanks.
+func findSeparator(line, separators string) int {
+	blank := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.IndexByte(separators, c) >= 0:
+			return i
+		case !blank && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'):
+		case i != 0 && (c == ' ' || c == '\t'):
+			blank = true
+		default:
+			return -1
+		}
+	}
+	return -1
+}
+
+// ParseTrailerArg reads a trailer given as "token=value" or "token: value" on the command
+// line. The value may be left out.
+func ParseTrailerArg(arg string, opts TrailerOptions) (Trailer, error) {
+	i := findSeparator(arg, "="+opts.separators())
+	if i == 0 || strings.TrimSpace(arg) == "" {
+		return Trailer{}, fmt.Errorf("empty trailer token in trailer '%s'", arg)
+	}
+	if i < 0 {
+		return opts.trailer(arg, ""), nil
+	}
+	return opts.trailer(arg[:i], arg[i+1:]), nil
+}
+
+// TrailerBlock is a message split around the trailers in its last paragraph.
+type TrailerBlock struct {
+	Trailers []Trailer
+
+	message     string
+	start, end  int
+	blankBefore bool
+	opts        TrailerOptions
+}
+
+// lineStarts returns the offset of every line in text.
+func lineStarts(text string) []int {
+	starts := make([]int, 0)
+	for i := 0; i < len(text); {
+		starts = append(starts, i)
+		next := strings.IndexByte(text[i:], '\n')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return starts
+}
+
+func lineAt(text string, start int) string {
+	line, _, _ := strings.Cut(text[start:], "\n")
+	return line
+}
+
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// endOfLogMessage is where the part of a message that can hold trailers ends: before a
+// "---" line that starts a patch, and before the comments and blank lines at the end.
+func endOfLogMessage(message string) int {
+	end := len(message)
+	for _, start := range lineStarts(message) {
+		if rest, ok := strings.CutPrefix(message[start:], "---"); ok && rest != "" && strings.IndexByte(" \t\n\r", rest[0]) >= 0 {
+			end = start
+			break
+		}
+	}
+
+	cutoff := end
+	if strings.HasPrefix(message[:end], scissorsLine) {
+		cutoff = 0
+	} else if i := strings.Index(message[:end], "\n"+scissorsLine); i >= 0 {
+		cutoff = i + 1
+	}
+
+	// the start of the comments, blank lines and old "Conflicts:" lists at the end
+	trailing := -1
+	conflicts := false
+	for _, start := range lineStarts(message[:cutoff]) {
+		line := lineAt(message[:end], start)
+		switch {
+		case strings.HasPrefix(line, "#") || line == "":
+			if trailing < 0 {
+				trailing = start
+			}
+		case line == "Conflicts:":
+			conflicts = true
+			if trailing < 0 {
+				trailing = start
+			}
+		case conflicts && strings.HasPrefix(line, "\t"):
+		default:
+			trailing = -1
+			conflicts = false
+		}
+	}
+	if trailing >= 0 {
+		return trailing
+	}
+	return cutoff
+}
+
+// blockStart finds the last paragraph of message[:end] after the subject if it is made of
+// trailers: either all of its lines are, or it has one git writes itself, like
+// Signed-off-by, and a quarter of its lines are. It returns end if there are no trailers.
+func (o TrailerOptions) blockStart(message string, end int) int {
+	starts := lineStarts(message[:end])
+
+	endOfTitle := end
+	for _, start := range lineStarts(message) {
+		line := lineAt(message, start)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isBlankLine(line) {
+			endOfTitle = start
+			break
+		}
+	}
+
+	onlySpaces := true
+	recognized := false
+	trailerLines, nonTrailerLines, continuationLines := 0, 0, 0
+	for i := len(starts) - 1; i >= 0 && starts[i] >= endOfTitle; i-- {
+		line := lineAt(message[:end], starts[i])
+		if strings.HasPrefix(line, "#") {
+			nonTrailerLines += continuationLines
+			continuationLines = 0
+			continue
+		}
+		if isBlankLine(line) {
+			if onlySpaces {
+				continue
+			}
+			nonTrailerLines += continuationLines
+			if recognized && trailerLines*3 >= nonTrailerLines || trailerLines != 0 && nonTrailerLines == 0 {
+				return starts[i+1]
+			}
+			return end
+		}
+		onlySpaces = false
+
+		if slices.ContainsFunc(gitGeneratedPrefixes, func(prefix string) bool { return strings.HasPrefix(line, prefix) }) {
+			trailerLines++
+			continuationLines = 0
+			recognized = true
+			continue
+		}
+		switch sep := findSeparator(line, o.separators()); {
+		case sep >= 1 && line[0] != ' ' && line[0] != '\t':
+			trailerLines++
+			continuationLines = 0
+			if _, ok := o.configured(strings.TrimSpace(line[:sep])); ok {
+				recognized = true
+			}
+		case line[0] == ' ' || line[0] == '\t':
+			continuationLines++
+		default:
+			nonTrailerLines += 1 + continuationLines
+			continuationLines = 0
+		}
+	}
+	return end
+}
+
+// ParseTrailers finds the trailer block of a message.
+func ParseTrailers(message string, opts TrailerOptions) *TrailerBlock {
+	end := endOfLogMessage(message)
+	start := opts.blockStart(message, end)
+
+	block := &TrailerBlock{
+		Trailers: make([]Trailer, 0),
+		message:  message,
+		start:    start,
+		end:      end,
+		opts:     opts,
+	}
+	starts := lineStarts(message[:start])
+	block.blankBefore = len(starts) != 0 && isBlankLine(lineAt(message, starts[len(starts)-1]))
+
+	// the trailer that a continuation line belongs to, or -1 after a line that isn't one
+	last := -1
+	for _, lineStart := range lineStarts(message[start:end]) {
+		line := lineAt(message[start:end], lineStart)
+		switch sep := findSeparator(line, opts.separators()); {
+		case strings.HasPrefix(line, "#"):
+			last = -1
+		case last >= 0 && line != "" && (line[0] == ' ' || line[0] == '\t'):
+			block.Trailers[last].Value += "\n" + strings.TrimRight(line, " \t\r")
+		case sep >= 1:
+			block.Trailers = append(block.Trailers, opts.trailer(line[:sep], line[sep+1:]))
+			last = len(block.Trailers) - 1
+		default:
+			block.Trailers = append(block.Trailers, Trailer{Value: line})
+			last = -1
+		}
+	}
+	return block
+}
+
+func sameTrailer(a, b Trailer) bool {
+	return strings.EqualFold(a.Token, b.Token) && strings.EqualFold(a.Value, b.Value)
+}
+
+// Add puts trailer at the end of the block. When a trailer with the same token is already
+// there, ifExists decides what happens, or the configured action if it is empty:
+// addIfDifferentNeighbor skips it if the last trailer is the same, addIfDifferent if
+// any is, replace drops the last trailer with the token and doNothing skips it.
+func (b *TrailerBlock) Add(trailer Trailer, ifExists string) {
+	if ifExists == "" {
+		c, _ := b.opts.configured(trailer.Token)
+		ifExists = cmp.Or(c.IfExists, b.opts.IfExists)
+	}
+
+	existing := -1
+	for i := len(b.Trailers) - 1; i >= 0; i-- {
+		if b.Trailers[i].Token != "" && strings.EqualFold(b.Trailers[i].Token, trailer.Token) {
+			existing = i
+			break
+		}
+	}
+	if existing >= 0 {
+		switch strings.ToLower(ifExists) {
+		case "add":
+		case "addifdifferent":
+			if slices.ContainsFunc(b.Trailers, func(t Trailer) bool { return sameTrailer(t, trailer) }) {
+				return
+			}
+		case "replace":
+			b.Trailers = slices.Delete(b.Trailers, existing, existing+1)
+		case "donothing":
+			return
+		default:
+			if sameTrailer(b.Trailers[len(b.Trailers)-1], trailer) {
+				return
+			}
+		}
+	}
+	b.Trailers = append(b.Trailers, trailer)
+}
+
+// Format writes a trailer back as a line, with the first separator unless the token
+// already ends in one.
+func (b *TrailerBlock) Format(trailer Trailer) string {
+	if trailer.Token == "" {
+		return trailer.Value
+	}
+	token := strings.TrimRight(trailer.Token, " \t")
+	if token != "" && strings.IndexByte(b.opts.separators(), token[len(token)-1]) >= 0 {
+		return trailer.Token + trailer.Value
+	}
+	return fmt.Sprintf("%s%c %s", trailer.Token, b.opts.separators()[0], trailer.Value)
+}
+
+// String is the message with the trailer block written back.
+func (b *TrailerBlock) String() string {
+	var out strings.Builder
+	out.WriteString(b.message[:b.start])
+	if !b.blankBefore {
+		out.WriteString("\n")
+	}
+	for _, trailer := range b.Trailers {
+		out.WriteString(b.Format(trailer) + "\n")
+	}
+	out.WriteString(b.message[b.end:])
+	return out.String()
+}
+
+// Parsed lists only the trailers, with continuation lines joined into their values.
+func (b *TrailerBlock) Parsed() string {
+	var out strings.Builder
+	for _, trailer := range b.Trailers {
+		if trailer.Token == "" {
+			continue
+		}
+		trailer.Value = unfold(trailer.Value)
+		out.WriteString(b.Format(trailer) + "\n")
+	}
+	return out.String()
+}
+
+// unfold joins continuation lines into a value, each with a single space.
+func unfold(value string) string {
+	lines := strings.Split(value, "\n")
+	for i := 1; i < len(lines); i++ {
+		lines[i] = strings.TrimLeft(lines[i], " \t\r")
+	}
+	return strings.TrimSpace(strings.Join(lines, " "))
+}
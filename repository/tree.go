@@ -5,60 +5,163 @@ import (
 	"cmp"
 	"encoding/hex"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 )
 
+// FileMode is the mode of a tree entry: the object type in the bits above the nine
+// permission bits, e.g. 0o100755 for an executable file.
+type FileMode uint32
+
+const (
+	ModeDir        FileMode = 0o040000
+	ModeRegular    FileMode = 0o100644
+	ModeExecutable FileMode = 0o100755
+	ModeSymlink    FileMode = 0o120000
+	ModeGitlink    FileMode = 0o160000
+)
+
+func ParseFileMode(mode []byte) (FileMode, error) {
+	value, err := strconv.ParseUint(string(mode), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %s", string(mode))
+	}
+	return FileMode(value), nil
+}
+
+// Type is the object type part of the mode, e.g. 0b1000 for a regular file.
+func (m FileMode) Type() int {
+	return int(m >> 12)
+}
+
+func (m FileMode) Perms() int {
+	return int(m & 0o777)
+}
+
+func (m FileMode) IsDir() bool {
+	return m.Type() == ModeDir.Type()
+}
+
+// ObjectType is the type of object an entry with the mode names.
+func (m FileMode) ObjectType() (string, error) {
+	switch m.Type() {
+	case ModeDir.Type():
+		return "tree", nil
+	case ModeRegular.Type(), ModeSymlink.Type():
+		return "blob", nil
+	case ModeGitlink.Type():
+		return "commit", nil
+	}
+	return "", fmt.Errorf("invalid tree leaf mode %s", m)
+}
+
+func (m FileMode) String() string {
+	return fmt.Sprintf("%06o", uint32(m))
+}
+
 type TreeLeaf struct {
-	Mode []byte
+	Mode FileMode
 	Path string
 	Sha  string
+
+	// the mode as it was read, which may be padded unlike the "40000" git writes
+	rawMode []byte
 }
 
-func treeParseOne(raw []byte, start int) (int, TreeLeaf) {
-	x := start + bytes.Index(raw[start:], []byte{' '})
-	if !(x-start == 5 || x-start == 6) {
-		panic("invalid mode definition")
+func treeParseOne(raw []byte, start int) (int, TreeLeaf, error) {
+	x := bytes.IndexByte(raw[start:], ' ')
+	if x != 5 && x != 6 {
+		return 0, TreeLeaf{}, fmt.Errorf("invalid mode in tree entry")
 	}
+	x += start
 
-	mode := raw[start:x]
-	if len(mode) == 5 {
-		mode = append([]byte("0"), mode...)
+	rawMode := raw[start:x]
+	mode, err := ParseFileMode(rawMode)
+	if err != nil {
+		return 0, TreeLeaf{}, err
 	}
 
-	y := x + bytes.Index(raw[x:], []byte{'\x00'})
-	path := raw[x+1 : y]
+	y := bytes.IndexByte(raw[x:], '\x00')
+	if y < 0 {
+		return 0, TreeLeaf{}, fmt.Errorf("unterminated path in tree entry")
+	}
+	y += x
+	if y+21 > len(raw) {
+		return 0, TreeLeaf{}, fmt.Errorf("truncated object name in tree entry")
+	}
 
-	raw_sha := hex.EncodeToString(raw[y+1 : y+21])
-	sha := fmt.Sprintf("%020s", raw_sha)
 	return y + 21, TreeLeaf{
-		Mode: mode,
-		Path: string(path),
-		Sha:  sha,
-	}
+		Mode:    mode,
+		Path:    string(raw[x+1 : y]),
+		Sha:     hex.EncodeToString(raw[y+1 : y+21]),
+		rawMode: rawMode,
+	}, nil
 }
 
-func TreeParse(raw []byte) []TreeLeaf {
+func TreeParse(raw []byte) ([]TreeLeaf, error) {
 	pos := 0
 	max := len(raw)
 	ret := make([]TreeLeaf, 0)
 	for pos < max {
 		var data TreeLeaf
-		pos, data = treeParseOne(raw, pos)
+		var err error
+		pos, data, err = treeParseOne(raw, pos)
+		if err != nil {
+			return nil, err
+		}
 		ret = append(ret, data)
 	}
 
-	return ret
+	return ret, nil
 }
 
+// serializedMode is the mode written for the leaf: the one it was read with, unless the
+// mode has been changed since, and otherwise the mode without padding.
+func (l TreeLeaf) serializedMode() []byte {
+	if mode, err := ParseFileMode(l.rawMode); err == nil && mode == l.Mode {
+		return l.rawMode
+	}
+	return fmt.Appendf(nil, "%o", uint32(l.Mode))
+}
+
+// treeLeafSort orders leaves the way git does, comparing subtrees as if their names
+// ended in a slash.
 func treeLeafSort(a, b TreeLeaf) int {
 	aPath := a.Path
-	if bytes.HasPrefix(a.Mode, []byte("10")) {
+	if a.Mode.IsDir() {
 		aPath += "/"
 	}
 
 	bPath := b.Path
-	if bytes.HasPrefix(b.Mode, []byte("10")) {
+	if b.Mode.IsDir() {
 		bPath += "/"
 	}
 
 	return cmp.Compare(aPath, bPath)
 }
+
+// BuildTree makes a tree of leaves, checking that they can be recorded and putting them in
+// the order git requires.
+func BuildTree(leaves []TreeLeaf) (*Tree, error) {
+	seen := make(map[string]bool)
+	for _, leaf := range leaves {
+		if leaf.Path == "" || leaf.Path == "." || leaf.Path == ".." || strings.ContainsAny(leaf.Path, "/\x00") {
+			return nil, fmt.Errorf("invalid path '%s' in tree", leaf.Path)
+		}
+		if !slices.Contains([]FileMode{ModeDir, ModeRegular, ModeExecutable, ModeSymlink, ModeGitlink}, leaf.Mode) {
+			return nil, fmt.Errorf("invalid mode %s for '%s'", leaf.Mode, leaf.Path)
+		}
+		if !ValidObjectName(leaf.Sha) {
+			return nil, fmt.Errorf("not a valid object name %s", leaf.Sha)
+		}
+		if seen[leaf.Path] {
+			return nil, fmt.Errorf("duplicate entry '%s' in tree", leaf.Path)
+		}
+		seen[leaf.Path] = true
+	}
+
+	tree := &Tree{Items: slices.Clone(leaves)}
+	tree.Sort()
+	return tree, nil
+}
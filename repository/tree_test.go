@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func FuzzTree(f *testing.F) {
+	sha, _ := hex.DecodeString("29ff16c9c14e2652b22f8b78bb08a5a07930c147")
+	leaf := func(mode, path string) []byte {
+		return append([]byte(mode+" "+path+"\x00"), sha...)
+	}
+	f.Add(leaf("100644", "README"))
+	f.Add(bytes.Join([][]byte{
+		leaf("100755", "build.sh"),
+		leaf("120000", "link"),
+		leaf("40000", "src"),
+		leaf("160000", "vendor"),
+	}, nil))
+	// old trees may pad the mode of subtrees
+	f.Add(leaf("040000", "dir"))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		items, err := TreeParse(raw)
+		if err != nil {
+			return
+		}
+		tree := Tree{Items: items}
+		if got := tree.Serialize(); !bytes.Equal(got, raw) {
+			t.Errorf("Serialize(TreeParse(%q)) = %q", raw, got)
+		}
+	})
+}
@@ -0,0 +1,466 @@
+package repository
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// TrustFileMode reports whether the executable bit of worktree files is meaningful,
+// following core.filemode.
+func (r *Repository) TrustFileMode() bool {
+	return r.Conf.Section("core").Key("filemode").MustBool(true)
+}
+
+func (r *Repository) statMode(stat fs.FileInfo) int {
+	switch {
+	case stat.Mode()&fs.ModeSymlink != 0:
+		return 0o120000
+	case r.TrustFileMode() && stat.Mode()&0o111 != 0:
+		return 0o100755
+	default:
+		return 0o100644
+	}
+}
+
+// WorktreeMode returns the mode a worktree file would be staged with.
+func (r *Repository) WorktreeMode(relPath string) (int, error) {
+	stat, err := os.Lstat(filepath.Join(r.Worktree, relPath))
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat file: %s", relPath)
+	}
+	if stat.IsDir() {
+		return 0, fmt.Errorf("not a file: %s", relPath)
+	}
+	return r.statMode(stat), nil
+}
+
+func (r *Repository) IndexEntryFromFile(relPath, sha string) (IndexEntry, error) {
+	absPath := filepath.Join(r.Worktree, relPath)
+
+	stat, err := os.Lstat(absPath)
+	if err != nil {
+		return IndexEntry{}, fmt.Errorf("cannot stat file: %s", absPath)
+	}
+	if stat.IsDir() {
+		return IndexEntry{}, fmt.Errorf("not a file: %s", relPath)
+	}
+	sysStat, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return IndexEntry{}, fmt.Errorf("not syscall stat")
+	}
+	mode := r.statMode(stat)
+
+	return IndexEntry{
+		Ctime:       time.Unix(sysStat.Ctim.Sec, sysStat.Ctim.Nsec),
+		Mtime:       stat.ModTime(),
+		Dev:         int(sysStat.Dev),
+		Ino:         int(sysStat.Ino),
+		ModeType:    mode >> 12,
+		ModePerms:   mode & 0o777,
+		Uid:         int(sysStat.Uid),
+		Gid:         int(sysStat.Gid),
+		Fsize:       int(stat.Size()),
+		Sha:         sha,
+		AssumeValid: false,
+		Stage:       0,
+		Name:        relPath,
+	}, nil
+}
+
+// ReadWorktreeFile returns the blob content of a worktree file. Symlinks are read as
+// their target path.
+func (r *Repository) ReadWorktreeFile(relPath string) ([]byte, error) {
+	absPath := filepath.Join(r.Worktree, relPath)
+
+	if target, err := os.Readlink(absPath); err == nil {
+		return []byte(target), nil
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read file: %s", relPath)
+	}
+	return data, nil
+}
+
+func (r *Repository) HashFile(relPath string, write bool) (string, error) {
+	data, err := r.ReadWorktreeFile(relPath)
+	if err != nil {
+		return "", err
+	}
+
+	var repo *Repository
+	if write {
+		repo = r
+	}
+	return Write(NewBlob(data), repo)
+}
+
+func (i *Index) Sort() {
+	slices.SortFunc(i.Entries, func(a, b IndexEntry) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Stage, b.Stage))
+	})
+}
+
+func (i *Index) Dict() map[string]string {
+	ret := make(map[string]string)
+	for _, e := range i.Entries {
+		if e.Stage == 0 && !e.IntentToAdd {
+			ret[e.Name] = e.Sha
+		}
+	}
+	return ret
+}
+
+func (i *Index) Modes() map[string]int {
+	ret := make(map[string]int)
+	for _, e := range i.Entries {
+		if e.Stage == 0 && !e.IntentToAdd {
+			ret[e.Name] = e.Mode()
+		}
+	}
+	return ret
+}
+
+func (i *Index) Unmerged() []string {
+	ret := make([]string, 0)
+	for _, e := range i.Entries {
+		if e.Stage != 0 && !slices.Contains(ret, e.Name) {
+			ret = append(ret, e.Name)
+		}
+	}
+	return ret
+}
+
+// IndexFromDict builds an index for a flattened tree and its modes (as returned by
+// TreeToModes, missing paths are regular files). Stat data is only recorded for files
+// whose worktree content already matches, so anything else is re-hashed by status.
+func (r *Repository) IndexFromDict(tree map[string]string, modes map[string]int) (*Index, error) {
+	index := NewIndexV2(nil)
+	index.Version = r.indexVersion()
+
+	for name, sha := range tree {
+		entry := IndexEntry{Sha: sha, Name: name}
+
+		if onDisk, err := r.HashFile(name, false); err == nil && onDisk == sha {
+			entry, err = r.IndexEntryFromFile(name, sha)
+			if err != nil {
+				return nil, err
+			}
+		}
+		entry.SetMode(cmp.Or(modes[name], 0o100644))
+
+		index.Entries = append(index.Entries, entry)
+	}
+
+	index.Sort()
+	return &index, nil
+}
+
+// WriteWorktreeFile writes a blob to the worktree with the given tree mode: symlinks
+// (0o120000) are created pointing at data, everything else is a regular file.
+func (r *Repository) WriteWorktreeFile(relPath string, data []byte, mode int) error {
+	absPath := filepath.Join(r.Worktree, relPath)
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return fmt.Errorf("error creating directories for %s", relPath)
+	}
+	if stat, err := os.Lstat(absPath); err == nil && (mode == 0o120000 || stat.Mode()&fs.ModeSymlink != 0) {
+		if err := os.Remove(absPath); err != nil {
+			return fmt.Errorf("cannot remove file %s", relPath)
+		}
+	}
+
+	if mode == 0o120000 {
+		if err := os.Symlink(string(data), absPath); err != nil {
+			return fmt.Errorf("cannot create symlink %s", relPath)
+		}
+		return nil
+	}
+
+	perm := os.FileMode(0644)
+	if mode&0o111 != 0 {
+		perm = 0755
+	}
+	if err := os.WriteFile(absPath, data, perm); err != nil {
+		return fmt.Errorf("cannot write file %s", relPath)
+	}
+	if err := os.Chmod(absPath, perm); err != nil {
+		return fmt.Errorf("cannot change mode of %s", relPath)
+	}
+	return nil
+}
+
+func (r *Repository) RemoveWorktreeFile(relPath string) error {
+	absPath := filepath.Join(r.Worktree, relPath)
+	if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove file %s", relPath)
+	}
+
+	for dir := filepath.Dir(absPath); dir != r.Worktree && dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// CheckoutDict moves the worktree from the flattened tree `from` to `to` (with the
+// modes of `to`), only touching paths whose content or mode differs.
+func (r *Repository) CheckoutDict(from, to map[string]string, modes map[string]int) error {
+	for path := range from {
+		if _, ok := to[path]; !ok {
+			if err := r.RemoveWorktreeFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for path, sha := range to {
+		mode := cmp.Or(modes[path], 0o100644)
+		if from[path] == sha {
+			if onDisk, err := r.WorktreeMode(path); err == nil && r.sameMode(onDisk, mode) {
+				continue
+			}
+		}
+
+		data, err := r.readBlobData(sha)
+		if err != nil {
+			return err
+		}
+		if err := r.WriteWorktreeFile(path, data, mode); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WorktreeChanges lists the tracked paths whose worktree content differs from the index.
+func (r *Repository) WorktreeChanges(index *Index) ([]string, error) {
+	ret := make([]string, 0)
+
+	for _, entry := range index.Entries {
+		if entry.Stage != 0 {
+			if !slices.Contains(ret, entry.Name) {
+				ret = append(ret, entry.Name)
+			}
+			continue
+		}
+
+		if entry.SkipWorktree || entry.AssumeValid {
+			continue
+		}
+		if entry.IntentToAdd {
+			ret = append(ret, entry.Name)
+			continue
+		}
+
+		changed, err := r.EntryChanged(index, entry)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			ret = append(ret, entry.Name)
+		}
+	}
+
+	return ret, nil
+}
+
+const emptyBlobSha = "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391"
+
+// matchStat reports whether the stat data recorded in entry still describes the file.
+// Only the bits the index can hold are compared.
+func (r *Repository) matchStat(entry IndexEntry, stat fs.FileInfo) bool {
+	sysStat, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	// a zero size marks an entry smudged because it was racily modified
+	if entry.Fsize == 0 && entry.Sha != emptyBlobSha {
+		return false
+	}
+	ctime := time.Unix(sysStat.Ctim.Sec, sysStat.Ctim.Nsec)
+
+	return uint32(entry.Mtime.Unix()) == uint32(stat.ModTime().Unix()) &&
+		entry.Mtime.Nanosecond() == stat.ModTime().Nanosecond() &&
+		uint32(entry.Ctime.Unix()) == uint32(ctime.Unix()) &&
+		entry.Ctime.Nanosecond() == ctime.Nanosecond() &&
+		uint32(entry.Fsize) == uint32(stat.Size()) &&
+		uint32(entry.Ino) == uint32(sysStat.Ino) &&
+		uint32(entry.Dev) == uint32(sysStat.Dev) &&
+		uint32(entry.Uid) == sysStat.Uid &&
+		uint32(entry.Gid) == sysStat.Gid &&
+		r.sameMode(r.statMode(stat), entry.Mode())
+}
+
+// EntryUptodate reports whether the worktree file can be assumed to match entry without
+// reading it: its stat data is unchanged and was not recorded too close to the index
+// being written to tell a later edit apart.
+func (r *Repository) EntryUptodate(index *Index, entry IndexEntry) bool {
+	stat, err := os.Lstat(filepath.Join(r.Worktree, entry.Name))
+	return err == nil && r.matchStat(entry, stat) && !index.racy(entry)
+}
+
+// EntryChanged reports whether the worktree file differs from entry in content or mode,
+// only hashing it when the stat data can't tell.
+func (r *Repository) EntryChanged(index *Index, entry IndexEntry) (bool, error) {
+	if r.EntryUptodate(index, entry) {
+		return false, nil
+	}
+	stat, err := os.Lstat(filepath.Join(r.Worktree, entry.Name))
+	if err != nil || !r.sameMode(r.statMode(stat), entry.Mode()) {
+		return true, nil
+	}
+	sha, err := r.HashFile(entry.Name, false)
+	if err != nil {
+		return false, err
+	}
+	return sha != entry.Sha, nil
+}
+
+// RefreshIndex records fresh stat data for entries whose worktree file is unchanged but
+// whose stat data is out of date or racy. It returns the paths that do differ and
+// whether the index needs writing. Assume-unchanged entries are only checked when really
+// is set.
+func (r *Repository) RefreshIndex(index *Index, really bool) ([]string, bool, error) {
+	stale := make([]string, 0)
+	refreshed := false
+
+	for i, entry := range index.Entries {
+		if entry.Stage != 0 {
+			if !slices.Contains(stale, entry.Name) {
+				stale = append(stale, entry.Name)
+			}
+			continue
+		}
+		if entry.SkipWorktree || entry.IntentToAdd || (entry.AssumeValid && !really) {
+			continue
+		}
+		if r.EntryUptodate(index, entry) {
+			continue
+		}
+
+		changed, err := r.EntryChanged(index, entry)
+		if err != nil {
+			return nil, false, err
+		}
+		if changed {
+			stale = append(stale, entry.Name)
+			continue
+		}
+
+		fresh, err := r.IndexEntryFromFile(entry.Name, entry.Sha)
+		if err != nil {
+			return nil, false, err
+		}
+		fresh.SetMode(entry.Mode())
+		fresh.AssumeValid = entry.AssumeValid
+		index.Entries[i] = fresh
+		refreshed = true
+	}
+
+	return stale, refreshed, nil
+}
+
+// StageMode returns the mode to stage for a file found on disk with onDisk when the index
+// recorded `recorded`. Without core.filemode the executable bit is kept from the index.
+func (r *Repository) StageMode(onDisk, recorded int) int {
+	if r.TrustFileMode() || onDisk>>12 != recorded>>12 {
+		return onDisk
+	}
+	return recorded
+}
+
+// sameMode compares a worktree mode with a recorded one, ignoring the executable bit
+// when core.filemode is off.
+func (r *Repository) sameMode(onDisk, recorded int) bool {
+	if r.TrustFileMode() {
+		return onDisk == recorded
+	}
+	return onDisk>>12 == recorded>>12
+}
+
+// IndexChanges lists the paths whose staged content or mode differs from the given
+// flattened tree and its modes (as returned by TreeToModes, missing paths are regular files).
+func IndexChanges(tree map[string]string, modes map[string]int, index *Index) []string {
+	ret := make([]string, 0)
+	staged := index.Dict()
+	stagedModes := index.Modes()
+
+	for path, sha := range staged {
+		if tree[path] != sha || cmp.Or(modes[path], 0o100644) != stagedModes[path] {
+			ret = append(ret, path)
+		}
+	}
+	for path := range tree {
+		if _, ok := staged[path]; !ok {
+			ret = append(ret, path)
+		}
+	}
+	for _, path := range index.Unmerged() {
+		if !slices.Contains(ret, path) {
+			ret = append(ret, path)
+		}
+	}
+
+	slices.Sort(ret)
+	return ret
+}
+
+// UntrackedFiles lists the worktree files that are not in the index, split by whether
+// they are ignored.
+func (r *Repository) UntrackedFiles(index *Index) ([]string, []string, error) {
+	rules, err := r.ReadGitignore()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tracked := make(map[string]bool)
+	for _, e := range index.Entries {
+		tracked[e.Name] = true
+	}
+
+	untracked := make([]string, 0)
+	ignored := make([]string, 0)
+	err = filepath.WalkDir(r.Worktree, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == r.Gitdir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(r.Worktree, path)
+		if err != nil {
+			return err
+		}
+		if tracked[relPath] {
+			return nil
+		}
+
+		isIgnored, err := rules.CheckIgnore(relPath)
+		if err != nil {
+			return err
+		}
+		if isIgnored {
+			ignored = append(ignored, relPath)
+		} else {
+			untracked = append(untracked, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot walk worktree: %v", err)
+	}
+
+	return untracked, ignored, nil
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestIndexChangesMode(t *testing.T) {
+	sha := "29ff16c9c14e2652b22f8b78bb08a5a07930c147"
+	index := NewIndexV2(nil)
+	for _, name := range []string{"exec", "same"} {
+		entry := IndexEntry{Sha: sha, Name: name}
+		entry.SetMode(0o100644)
+		index.Entries = append(index.Entries, entry)
+	}
+	index.Entries[0].SetMode(0o100755)
+
+	tree := map[string]string{"exec": sha, "same": sha}
+	if got := IndexChanges(tree, map[string]int{}, &index); !slices.Equal(got, []string{"exec"}) {
+		t.Errorf("IndexChanges = %q, want only the mode change", got)
+	}
+	modes := map[string]int{"exec": 0o100755}
+	if got := IndexChanges(tree, modes, &index); len(got) != 0 {
+		t.Errorf("IndexChanges = %q, want none", got)
+	}
+}